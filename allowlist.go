@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// AllowlistGatherer 只返回 --metrics-allowlist 中的指标，采集器照常运行，只减小返回的数据量
+type AllowlistGatherer struct {
+	prometheus.Gatherer
+	names map[string]bool
+}
+
+// NewAllowlistGatherer names为空时不过滤，直接返回g
+func NewAllowlistGatherer(g prometheus.Gatherer, names []string) prometheus.Gatherer {
+	if len(names) == 0 {
+		return g
+	}
+	allowed := make(map[string]bool, len(names))
+	for _, name := range names {
+		allowed[name] = true
+	}
+	return AllowlistGatherer{Gatherer: g, names: allowed}
+}
+
+func (g AllowlistGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	filtered := families[:0]
+	for _, family := range families {
+		if g.names[family.GetName()] {
+			filtered = append(filtered, family)
+		}
+	}
+	return filtered, err
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestAllowlistExposition(t *testing.T) {
+	useContainers(t, benchContainers(3)...)
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(testExporter("state", "counts", "images"), NewStartTime())
+	allowed := []string{"container_run_state", "container_image_usage_count", "container_no_such_metric"}
+	server := httptest.NewServer(MetricsHandler(NewAllowlistGatherer(reg, allowed)))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// 不存在的指标名不影响结果
+	if got := strings.Join(names, ","); got != "container_image_usage_count,container_run_state" {
+		t.Errorf("exposed metrics = %s, want only the allowed ones", got)
+	}
+	if n := len(families["container_run_state"].GetMetric()); n != 3 {
+		t.Errorf("container_run_state has %d series, want 3", n)
+	}
+}
+
+func TestAllowlistEmpty(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	if g := NewAllowlistGatherer(reg, nil); g != prometheus.Gatherer(reg) {
+		t.Error("empty allowlist should not wrap the gatherer")
+	}
+}
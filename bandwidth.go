@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tc使用的速率单位对应的每秒字节数，不带单位时与tc一样表示bit/s
+var bandwidthUnits = map[string]float64{
+	"":      1.0 / 8,
+	"bit":   1.0 / 8,
+	"kbit":  1e3 / 8,
+	"mbit":  1e6 / 8,
+	"gbit":  1e9 / 8,
+	"tbit":  1e12 / 8,
+	"kibit": 1 << 10 / 8,
+	"mibit": 1 << 20 / 8,
+	"gibit": 1 << 30 / 8,
+	"tibit": 1 << 40 / 8,
+	"bps":   1,
+	"kbps":  1e3,
+	"mbps":  1e6,
+	"gbps":  1e9,
+	"tbps":  1e12,
+	"kibps": 1 << 10,
+	"mibps": 1 << 20,
+	"gibps": 1 << 30,
+	"tibps": 1 << 40,
+}
+
+// ParseBandwidth 解析tc格式的速率，例如 10mbit、1.5gbit、100kbps，返回每秒字节数
+func ParseBandwidth(s string) (float64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(s)
+	}
+	unit, ok := bandwidthUnits[s[i:]]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q of bandwidth %q", s[i:], s)
+	}
+	value, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid bandwidth %q", s)
+	}
+	return value * unit, nil
+}
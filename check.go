@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RunCheck 执行 --check：依次检查连接daemon、列出容器，以及启用了相应采集器时的inspect和stats权限，
+// 最后完整执行一次采集，把每一步的结果写到w，有任何一步失败时返回false
+func RunCheck(w io.Writer, config *Config, collectors map[string]bool) bool {
+	ok := true
+	step := func(name string, err error, detail string) {
+		if err != nil {
+			ok = false
+			fmt.Fprintf(w, "FAIL  %s: %v\n", name, err)
+			return
+		}
+		fmt.Fprintf(w, "ok    %s%s\n", name, detail)
+	}
+
+	if *backend == "docker" && *sourceFile == "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		ping, err := DockerClient.Ping(ctx)
+		cancel()
+		step("connect "+DockerClient.DaemonHost(), err, fmt.Sprintf(", API version %s", ping.APIVersion))
+		if err != nil {
+			return false
+		}
+	}
+
+	containerList, err := GetContainerList()
+	step("list containers", err, fmt.Sprintf(", %d containers", len(containerList)))
+	if err != nil {
+		return false
+	}
+
+	e := NewExporter(config, collectors)
+	if e.needInspect() && len(containerList) > 0 {
+		info := containerList[0]
+		_, err := DockerClient.ContainerInspect(context.Background(), info.ID)
+		step("inspect container "+info.ID, err, "")
+	}
+	if collectors["stats"] {
+		for _, info := range containerList {
+			if GetContainerState(info.State) == "running" {
+				_, err := GetContainerStats(info.ID)
+				step("stats of container "+info.ID, err, "")
+				break
+			}
+		}
+	}
+
+	// 与正常采集相同的路径，采集器自身的错误(daemon、swarm-tasks等)在这里暴露
+	metrics := make(chan prometheus.Metric)
+	done := make(chan int)
+	go func() {
+		n := 0
+		for range metrics {
+			n++
+		}
+		done <- n
+	}()
+	err = e.collect(metrics)
+	close(metrics)
+	step("collect", err, fmt.Sprintf(", %d metrics", <-done))
+	return ok
+}
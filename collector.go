@@ -0,0 +1,199 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// AvailableCollectors 所有可选的采集器，名称用于 --collectors、--collect-<name> 和 --no-collector.<name>
+var AvailableCollectors = []struct {
+	Name string
+	Help string
+}{
+	{"state", "container_run_state of every container"},
+	{"counts", "number of mounts, networks and ports of every container"},
+	{"ports", "container_port_ok for the expected_ports of the config file"},
+	{"images", "container_image_usage_count of every image and version"},
+	{"labels", "container_required_labels_present for --require-labels and the gauges of --label-to-metric"},
+	{"bandwidth", "container_network_bandwidth_limit_bytes from the label given by --bandwidth-label"},
+	{"inspect", "metrics that need to inspect every container, e.g. container_start_time_seconds"},
+	{"image-age", "image creation time and staleness, inspects containers and images"},
+	{"image-platform", "os and architecture of the image of every container, inspects images"},
+	{"network-info", "network settings of every container from inspect, e.g. container_on_default_bridge"},
+	{"gpu", "GPUs requested by every container from inspect"},
+	{"health", "container_health_status of every container with a healthcheck from inspect"},
+	{"stats", "resource usage of every running container from docker stats, e.g. CPU usage, CPU throttling and swap usage"},
+	{"log-size", "container_log_size_bytes of every container using the json-file log driver, stats the log files from inspect"},
+	{"last-seen", "container_last_seen_timestamp_seconds of every container"},
+	{"daemon", "version, default runtime and swarm membership of the docker daemon, refreshed every 5 minutes"},
+	{"swarm-tasks", "container_swarm_task_state of all tasks in the swarm, only on managers"},
+	{"events", "container_events_total and container_restarts_recent from the docker event stream"},
+}
+
+var (
+	collectorsFlag   *string
+	extendedFlag     *string
+	collectFlags     = map[string]*bool{}
+	noCollectorFlags = map[string]*bool{}
+)
+
+func init() {
+	names := make([]string, 0, len(AvailableCollectors))
+	for _, c := range AvailableCollectors {
+		names = append(names, c.Name)
+		collectFlags[c.Name] = flag.Bool("collect-"+c.Name, false, fmt.Sprintf("Enable the %s collector: %s.", c.Name, c.Help))
+		noCollectorFlags[c.Name] = flag.Bool("no-collector."+c.Name, false, fmt.Sprintf("Disable the %s collector.", c.Name))
+	}
+	collectorsFlag = flag.String("collectors", "state", "Comma-separated list of collectors to enable, available: "+strings.Join(names, ", ")+".")
+	extendedFlag = flag.String("extended-collectors", "", "Comma-separated list of collectors to enable and serve at /metrics/extended instead of /metrics, e.g. inspect,stats.")
+}
+
+// EnabledCollectors 根据 --collectors 以及 --collect-<name>/--no-collector.<name> 计算启用的采集器，
+// 默认的state采集器也可以用 --no-collector.state 关闭，只输出其他采集器的指标。未知的采集器名或者没有启用任何采集器时返回错误
+func EnabledCollectors() (map[string]bool, error) {
+	enabled := map[string]bool{}
+	for _, name := range SplitList(*collectorsFlag) {
+		if _, ok := collectFlags[name]; !ok {
+			return nil, fmt.Errorf("unknown collector %q", name)
+		}
+		enabled[name] = true
+	}
+	for name, collect := range collectFlags {
+		if *collect {
+			enabled[name] = true
+		}
+	}
+	for _, name := range SplitList(*extendedFlag) {
+		if _, ok := collectFlags[name]; !ok {
+			return nil, fmt.Errorf("unknown collector %q", name)
+		}
+		enabled[name] = true
+	}
+	for name, disable := range noCollectorFlags {
+		if *disable {
+			delete(enabled, name)
+		}
+	}
+	if len(enabled) == 0 {
+		return nil, fmt.Errorf("no collector is enabled, enable at least one with --collectors or --collect-<name>")
+	}
+	return enabled, nil
+}
+
+// SplitExtended 把 --extended-collectors 中的采集器从 /metrics 移到 /metrics/extended，
+// 两个路径各自使用一个registry，各自查询docker，可以在Prometheus中配置两个不同抓取间隔的job：
+//
+//	scrape_configs:
+//	  - job_name: container
+//	    scrape_interval: 15s
+//	    static_configs: [{targets: ["host:9417"]}]
+//	  - job_name: container-extended
+//	    scrape_interval: 2m
+//	    metrics_path: /metrics/extended
+//	    static_configs: [{targets: ["host:9417"]}]
+func SplitExtended(enabled map[string]bool) (basic, extended map[string]bool, err error) {
+	basic = make(map[string]bool, len(enabled))
+	for name := range enabled {
+		basic[name] = true
+	}
+	extended = map[string]bool{}
+	for _, name := range SplitList(*extendedFlag) {
+		// 事件在后台订阅，计数器只能注册到一个registry
+		if name == "events" {
+			return nil, nil, fmt.Errorf("the events collector can only be served at /metrics")
+		}
+		if basic[name] {
+			delete(basic, name)
+			extended[name] = true
+		}
+	}
+	return basic, extended, nil
+}
+
+// 进程启动的时间
+var startTime = time.Now()
+
+// NewStartTime 返回 container_exporter_start_time_seconds，用于计算exporter的运行时长
+func NewStartTime() prometheus.Gauge {
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "container_exporter_start_time_seconds",
+		Help:        "start time of the exporter process since unix epoch in seconds",
+		ConstLabels: constLabels,
+	})
+	gauge.Set(float64(startTime.UnixNano()) / 1e9)
+	return gauge
+}
+
+// NewProcessGauges 返回exporter自身的goroutine数量和打开的文件描述符数量，用于发现stats请求等泄漏，
+// 比完整的process和go collector轻量，文件描述符数量只在linux上通过 /proc/self/fd 统计
+func NewProcessGauges() []prometheus.Collector {
+	gauges := []prometheus.Collector{
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "container_exporter_goroutines",
+			Help:        "number of goroutines of the exporter",
+			ConstLabels: constLabels,
+		}, func() float64 {
+			return float64(runtime.NumGoroutine())
+		}),
+	}
+	if runtime.GOOS == "linux" {
+		gauges = append(gauges, prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "container_exporter_open_fds",
+			Help:        "number of open file descriptors of the exporter",
+			ConstLabels: constLabels,
+		}, func() float64 {
+			fds, err := ioutil.ReadDir("/proc/self/fd")
+			if err != nil {
+				return math.NaN()
+			}
+			return float64(len(fds))
+		}))
+	}
+	return gauges
+}
+
+// NewConfigInfo 返回 container_exporter_config_info，label只包含取值有限的关键配置，不包含地址、证书等信息
+func NewConfigInfo(collectors map[string]bool) prometheus.Gauge {
+	names := make([]string, 0, len(collectors))
+	for name := range collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	scheme := "unix"
+	if u, err := url.Parse(*dockerHost); err == nil && u.Scheme != "" {
+		scheme = u.Scheme
+	}
+	backendName := *backend
+	if *sourceFile != "" {
+		backendName = "file"
+	}
+	labels := prometheus.Labels{
+		"backend":            backendName,
+		"docker_host_scheme": scheme,
+		"all":                strconv.FormatBool(containerListOptions.All),
+		// 容器列表在后台按 --poll-interval 刷新时的最长缓存时间，0s表示每次采集都重新获取
+		"cache_ttl":    pollInterval.String(),
+		"event_driven": strconv.FormatBool(*eventDriven),
+		"collectors":   strings.Join(names, ","),
+	}
+	for name, value := range constLabels {
+		labels[name] = value
+	}
+	info := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "container_exporter_config_info",
+		Help:        "configuration of the exporter, value is always 1",
+		ConstLabels: labels,
+	})
+	info.Set(1)
+	return info
+}
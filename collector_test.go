@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestConfigInfo(t *testing.T) {
+	setFlag(t, "poll-interval", "30s")
+	families := gather(t, NewConfigInfo(map[string]bool{"state": true, "counts": true}))
+	info := families["container_exporter_config_info"]
+	if info == nil {
+		t.Fatal("container_exporter_config_info missing")
+	}
+	labels := labelsOf(info.GetMetric()[0])
+	want := map[string]string{
+		"all":        "true",
+		"cache_ttl":  "30s",
+		"collectors": "counts,state",
+	}
+	for name, value := range want {
+		if labels[name] != value {
+			t.Errorf("label %s = %q, want %q", name, labels[name], value)
+		}
+	}
+
+	// 不包含已停止的容器时all随之变化
+	old := containerListOptions.All
+	containerListOptions.All = false
+	t.Cleanup(func() { containerListOptions.All = old })
+	labels = labelsOf(gather(t, NewConfigInfo(map[string]bool{"state": true}))["container_exporter_config_info"].GetMetric()[0])
+	if labels["all"] != "false" {
+		t.Errorf("label all = %q with All=false, want \"false\"", labels["all"])
+	}
+}
+
+func TestNoCollectorState(t *testing.T) {
+	useContainers(t, benchContainers(2)...)
+	setFlag(t, "no-collector.state", "true")
+	setFlag(t, "collect-counts", "true")
+	collectors, err := EnabledCollectors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if collectors["state"] || !collectors["counts"] {
+		t.Fatalf("EnabledCollectors = %v, want counts without state", collectors)
+	}
+	families := gather(t, NewExporter(&Config{}, collectors))
+	for _, name := range []string{"container_run_state", "container_state_count", "container_state_changed"} {
+		if families[name] != nil {
+			t.Errorf("%s should be absent with --no-collector.state", name)
+		}
+	}
+	if families["container_mounts_count"] == nil {
+		t.Error("container_mounts_count missing")
+	}
+
+	// 关闭了所有采集器
+	setFlag(t, "collect-counts", "false")
+	if collectors, err := EnabledCollectors(); err == nil {
+		t.Errorf("EnabledCollectors = %v with every collector disabled, want error", collectors)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types/events"
+)
+
+// 并发采集的同时后台刷新ContainerStore，用 go test -race 检查共享状态的锁
+func TestConcurrentCollect(t *testing.T) {
+	fakeDocker(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			var list []string
+			for i := 0; i < 20; i++ {
+				state := "running"
+				if i%3 == 0 {
+					state = "exited"
+				}
+				list = append(list, fmt.Sprintf(`{"Id":"c%d","Names":["/app_%d"],"Image":"app:%d","ImageID":"sha256:%d","State":%q}`, i, i, i%4, i%4, state))
+			}
+			io.WriteString(w, "["+strings.Join(list, ",")+"]")
+		case strings.HasSuffix(r.URL.Path, "/stats"):
+			io.WriteString(w, `{"cpu_stats":{"cpu_usage":{"total_usage":200},"system_cpu_usage":2000,"online_cpus":2},
+				"precpu_stats":{"cpu_usage":{"total_usage":100},"system_cpu_usage":1000}}`)
+		case strings.Contains(r.URL.Path, "/images/"):
+			io.WriteString(w, `{"Id":"sha256:0","Os":"linux","Architecture":"amd64"}`)
+		case strings.HasSuffix(r.URL.Path, "/json"):
+			id := containerIDOf(r)
+			fmt.Fprintf(w, `{"Id":%q,"RestartCount":1,"State":{"Status":"running","Running":true,"Health":{"Status":"healthy"}}}`, id)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	setFlag(t, "serve-stale", "true")
+	store := useContainers(t)
+	if err := store.Connected(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	e := testExporter("state", "counts", "images", "inspect", "health", "stats", "image-platform", "last-seen")
+
+	// 事件流和轮询在后台更新容器列表，期间偶尔断开
+	ctx := context.Background()
+	updated := make(chan struct{})
+	go func() {
+		defer close(updated)
+		for i := 0; i < 50; i++ {
+			if i%10 == 9 {
+				store.Disconnected(fmt.Errorf("event stream closed"))
+			}
+			if err := store.Connected(ctx); err != nil {
+				t.Error(err)
+			}
+			store.Handle(ctx, events.Message{Type: events.ContainerEventType, Action: "start", Actor: events.Actor{ID: fmt.Sprintf("c%d", i%20)}})
+		}
+	}()
+	// HTTP抓取和推送同时采集，直到后台更新结束
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-updated:
+					return
+				default:
+					drain(e)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if runState := gather(t, e)["container_run_state"]; runState == nil || len(runState.GetMetric()) != 20 {
+		t.Errorf("container_run_state = %v, want 20 containers", runState)
+	}
+}
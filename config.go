@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+// Config 对应 --config.file 指定的配置文件
+type Config struct {
+	// 每个容器名期望对外发布的端口，例如 web: [80, 443]
+	ExpectedPorts map[string][]uint16 `yaml:"expected_ports"`
+	// 覆盖指标的label名称，例如 name: container_name
+	LabelNames LabelNames `yaml:"label_names"`
+	// 覆盖container_run_state的help信息
+	StateHelp string `yaml:"state_help"`
+	// 输出指标前对容器执行的relabel规则
+	RelabelConfigs []*RelabelConfig `yaml:"relabel_configs"`
+}
+
+// LabelNames 默认label名称到自定义名称的映射
+type LabelNames map[string]string
+
+// Rename 返回替换后的label名称，没有配置的保持不变
+func (m LabelNames) Rename(names ...string) []string {
+	renamed := make([]string, len(names))
+	for i, name := range names {
+		if custom, ok := m[name]; ok {
+			renamed[i] = custom
+		} else {
+			renamed[i] = name
+		}
+	}
+	return renamed
+}
+
+// defaultLabelNames 所有可以在label_names中重命名的默认label名称，即NewExporter中经过labels(...)的名称，
+// 增加新的label时需要同步添加
+var defaultLabelNames = []string{
+	"alias", "api_version", "apparmor", "architecture", "cap_add", "cap_drop", "capabilities", "cpuset",
+	"desired_state", "device_ids", "domain", "domainname", "driver", "exit_code", "from_state", "health",
+	"host", "hostname", "id", "image", "image_id", "interval", "ip", "last_output", "log_driver", "merged_dir",
+	"name", "namespace", "network", "node", "node_state", "os", "pod", "policy", "reason", "registry",
+	"retries", "runtime", "seccomp", "server", "server_version", "service", "slot", "source", "start_period",
+	"state", "status", "task_id", "timeout", "to_state", "ulimit", "upper_dir", "variant", "version",
+}
+
+// DefaultLabelNames 返回默认label名称的集合，包括 --expose-env 对应的 env_<name>
+func DefaultLabelNames() map[string]bool {
+	names := make(map[string]bool, len(defaultLabelNames))
+	for _, name := range defaultLabelNames {
+		names[name] = true
+	}
+	for _, env := range SplitList(*exposeEnv) {
+		names[EnvLabelName(env)] = true
+	}
+	return names
+}
+
+// Validate 检查重命名的是已有的label，自定义名称是合法的Prometheus label名称，
+// 互不重复，也不与没有重命名的默认label重名
+func (m LabelNames) Validate() error {
+	known := DefaultLabelNames()
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	// 按名称排序，多处错误时每次报告同一个
+	sort.Strings(names)
+	seen := map[string]string{}
+	for _, name := range names {
+		custom := m[name]
+		if !known[name] {
+			return fmt.Errorf("unknown label %q in label_names", name)
+		}
+		if _, renamed := m[custom]; custom != name && known[custom] && !renamed {
+			return fmt.Errorf("label %q is renamed to %q, which is already the name of another label", name, custom)
+		}
+		if !model.LabelName(custom).IsValid() || strings.HasPrefix(custom, "__") {
+			return fmt.Errorf("invalid label name %q for %q", custom, name)
+		}
+		if other, ok := seen[custom]; ok {
+			return fmt.Errorf("label %q and %q are both renamed to %q", other, name, custom)
+		}
+		seen[custom] = name
+	}
+	return nil
+}
+
+// constLabels --const-label 指定的label，附加到所有指标上
+var constLabels = prometheus.Labels{}
+
+func init() {
+	flag.Var(labelsFlag(constLabels), "const-label", "Static label added to every metric as key=value, e.g. env=prod, can be repeated.")
+}
+
+// labelsFlag 可以重复指定的 key=value 参数
+type labelsFlag prometheus.Labels
+
+func (f labelsFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for name, value := range f {
+		pairs = append(pairs, name+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f labelsFlag) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i < 0 {
+		return fmt.Errorf("expected key=value, got %q", s)
+	}
+	name, value := s[:i], s[i+1:]
+	if !model.LabelName(name).IsValid() || strings.HasPrefix(name, "__") {
+		return fmt.Errorf("invalid label name %q", name)
+	}
+	if _, ok := f[name]; ok {
+		return fmt.Errorf("label %q is given more than once", name)
+	}
+	f[name] = value
+	return nil
+}
+
+// LoadConfig 读取并解析配置文件，path为空时返回空配置
+func LoadConfig(path string) (*Config, error) {
+	config := &Config{}
+	if path == "" {
+		return config, nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file %s: %w", path, err)
+	}
+	if err := yaml.UnmarshalStrict(content, config); err != nil {
+		return nil, fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	if err := config.LabelNames.Validate(); err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	for _, relabel := range config.RelabelConfigs {
+		if err := relabel.Init(); err != nil {
+			return nil, fmt.Errorf("config file %s: %w", path, err)
+		}
+	}
+	return config, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestLabelNamesValidate(t *testing.T) {
+	setFlag(t, "expose-env", "APP_VER")
+	tests := []struct {
+		names LabelNames
+		err   string
+	}{
+		{LabelNames{"name": "container_name", "id": "container_id"}, ""},
+		// 互换名称也是允许的
+		{LabelNames{"name": "id", "id": "name"}, ""},
+		{LabelNames{"env_app_ver": "app_version"}, ""},
+		{LabelNames{"nmae": "container_name"}, `unknown label "nmae"`},
+		{LabelNames{"name": "id"}, `label "name" is renamed to "id"`},
+		{LabelNames{"name": "container", "image": "container"}, `are both renamed to "container"`},
+		{LabelNames{"name": "container-name"}, `invalid label name "container-name"`},
+		{LabelNames{"name": "__name"}, `invalid label name "__name"`},
+	}
+	for _, tt := range tests {
+		err := tt.names.Validate()
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("Validate(%v) = %v, want nil", tt.names, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("Validate(%v) = %v, want error containing %q", tt.names, err, tt.err)
+		}
+	}
+}
+
+// 所有采集器和可选label都开启时，Exporter使用的label都应该在defaultLabelNames中
+func TestDefaultLabelNamesComplete(t *testing.T) {
+	setFlag(t, "graphdriver-paths", "true")
+	setFlag(t, "health-output-length", "10")
+	setFlag(t, "swarm-tasks-as-containers", "true")
+	setFlag(t, "expose-env", "APP_VER")
+	all := make(map[string]bool, len(AvailableCollectors))
+	for _, c := range AvailableCollectors {
+		all[c.Name] = true
+	}
+	ch := make(chan *prometheus.Desc, 256)
+	go func() {
+		NewExporter(&Config{}, all).Describe(ch)
+		NewRestartTracker(0).Describe(ch)
+		close(ch)
+	}()
+	// 这些label不能重命名
+	fixed := map[string]bool{"error_type": true, "collector": true}
+	known := DefaultLabelNames()
+	variableLabels := regexp.MustCompile(`variableLabels: \[([^\]]*)\]`)
+	for desc := range ch {
+		match := variableLabels.FindStringSubmatch(desc.String())
+		if match == nil {
+			continue
+		}
+		for _, name := range strings.Fields(match[1]) {
+			if !known[name] && !fixed[name] {
+				t.Errorf("label %q of %s is missing from defaultLabelNames", name, desc)
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// 请求CRI的超时时间
+const criTimeout = 10 * time.Second
+
+// CRIContainerStates CRI容器状态对应的docker容器状态，CRI没有paused、restarting等状态
+var CRIContainerStates = map[runtimeapi.ContainerState]string{
+	runtimeapi.ContainerState_CONTAINER_CREATED: "created",
+	runtimeapi.ContainerState_CONTAINER_RUNNING: "running",
+	runtimeapi.ContainerState_CONTAINER_EXITED:  "exited",
+	runtimeapi.ContainerState_CONTAINER_UNKNOWN: "unknown",
+}
+
+// kubelet写入容器label的pod名称和namespace
+const (
+	podNameLabel      = "io.kubernetes.pod.name"
+	podNamespaceLabel = "io.kubernetes.pod.namespace"
+)
+
+// 依赖docker API的采集器，CRI的容器列表不包含挂载、网络和端口，同样不支持
+var dockerOnlyCollectors = append([]string{"counts", "ports", "image-platform", "daemon", "swarm-tasks", "events"}, inspectCollectors...)
+
+// CRIClient --backend cri 时全局复用的CRI客户端
+var CRIClient runtimeapi.RuntimeServiceClient
+
+// InitCRIConnect 连接 --cri-endpoint，只支持CRI v1，启动时请求一次版本，尽早暴露socket路径或API版本不对的问题
+func InitCRIConnect() error {
+	conn, err := grpc.Dial(*criEndpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	CRIClient = runtimeapi.NewRuntimeServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), criTimeout)
+	defer cancel()
+	if _, err := CRIClient.Version(ctx, &runtimeapi.VersionRequest{}); err != nil {
+		conn.Close()
+		return fmt.Errorf("query CRI version of %s, the runtime must support CRI v1: %w", *criEndpoint, err)
+	}
+	return nil
+}
+
+// ListCRIContainers 通过CRI列出所有容器，转换为与docker容器列表相同的结构，后续的过滤和指标无需区分后端
+func ListCRIContainers() ([]types.Container, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), criTimeout)
+	defer cancel()
+	resp, err := CRIClient.ListContainers(ctx, &runtimeapi.ListContainersRequest{})
+	if err != nil {
+		return nil, err
+	}
+	containerList := make([]types.Container, 0, len(resp.Containers))
+	for _, c := range resp.Containers {
+		containerList = append(containerList, CRIContainer(c))
+	}
+	return containerList, nil
+}
+
+// CRIContainer 把CRI容器转换为docker容器列表中的一项，CRI没有docker的Status描述，Status与State相同
+func CRIContainer(c *runtimeapi.Container) types.Container {
+	state, ok := CRIContainerStates[c.State]
+	if !ok {
+		state = "unknown"
+	}
+	return types.Container{
+		ID:      c.Id,
+		Names:   []string{"/" + CRIContainerName(c)},
+		Image:   c.GetImage().GetImage(),
+		ImageID: c.ImageRef,
+		Created: c.CreatedAt / int64(time.Second),
+		Labels:  c.Labels,
+		State:   state,
+		Status:  state,
+	}
+}
+
+// CRIContainerName 返回 <namespace>/<pod>/<container> 形式的名称。Metadata.Name是pod spec中的容器名，
+// sidecar和同一个deployment的多个副本都相同，不带pod时会被当作重名容器；不是kubelet创建的容器直接使用Metadata.Name
+func CRIContainerName(c *runtimeapi.Container) string {
+	name := c.GetMetadata().GetName()
+	if pod, namespace, ok := PodOf(c.Labels); ok {
+		return namespace + "/" + pod + "/" + name
+	}
+	return name
+}
+
+// PodOf 返回kubelet创建的容器所属的pod和namespace，不是kubelet创建的容器返回false
+func PodOf(labels map[string]string) (pod, namespace string, ok bool) {
+	pod, ok = labels[podNameLabel]
+	return pod, labels[podNamespaceLabel], ok
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+func TestCRIContainerName(t *testing.T) {
+	pod := func(namespace, name string) map[string]string {
+		return map[string]string{podNamespaceLabel: namespace, podNameLabel: name}
+	}
+	containers := []*runtimeapi.Container{
+		{Id: "a", Metadata: &runtimeapi.ContainerMetadata{Name: "app"}, Labels: pod("default", "web-5d8f-abcde")},
+		{Id: "b", Metadata: &runtimeapi.ContainerMetadata{Name: "app"}, Labels: pod("default", "web-5d8f-fghij")},
+		{Id: "c", Metadata: &runtimeapi.ContainerMetadata{Name: "istio-proxy"}, Labels: pod("default", "web-5d8f-abcde")},
+		{Id: "d", Metadata: &runtimeapi.ContainerMetadata{Name: "app"}, Labels: pod("staging", "web-5d8f-abcde")},
+		{Id: "e", Metadata: &runtimeapi.ContainerMetadata{Name: "standalone"}},
+	}
+	want := map[string]string{
+		"a": "default/web-5d8f-abcde/app",
+		"b": "default/web-5d8f-fghij/app",
+		"c": "default/web-5d8f-abcde/istio-proxy",
+		"d": "staging/web-5d8f-abcde/app",
+		"e": "standalone",
+	}
+	var selected []selectedContainer
+	for _, c := range containers {
+		info := CRIContainer(c)
+		if got := ContainerName(info); got != want[c.Id] {
+			t.Errorf("container %s name = %q, want %q", c.Id, got, want[c.Id])
+		}
+		selected = append(selected, selectedContainer{info: info, target: RelabelTarget{Name: ContainerName(info)}})
+	}
+	// 多个副本和sidecar使用相同的容器名时不算重名
+	if collisions := NameCollisions(selected); len(collisions) != 0 {
+		t.Errorf("NameCollisions = %v, want none", collisions)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// daemon级别的信息很少变化，不需要每次采集都查询
+const daemonInfoRefreshInterval = 5 * time.Minute
+
+// DaemonInfo 缓存docker daemon级别的信息，定期刷新，docker请求失败后(daemon可能重启或升级)下一次采集时立即刷新
+type DaemonInfo struct {
+	mu          sync.Mutex
+	refreshedAt time.Time
+
+	apiVersion     string
+	serverVersion  string
+	defaultRuntime string
+	// 节点被锁定(autolock)时仍然属于swarm
+	swarmActive bool
+}
+
+// Invalidate 下一次采集时重新查询
+func (d *DaemonInfo) Invalidate() {
+	d.mu.Lock()
+	d.refreshedAt = time.Time{}
+	d.mu.Unlock()
+}
+
+// refresh 距上次刷新超过daemonInfoRefreshInterval时重新查询，调用方需持有d.mu
+func (d *DaemonInfo) refresh(ctx context.Context) error {
+	if !d.refreshedAt.IsZero() && time.Since(d.refreshedAt) < daemonInfoRefreshInterval {
+		return nil
+	}
+	version, err := DockerClient.ServerVersion(ctx)
+	if err != nil {
+		return err
+	}
+	info, err := DockerClient.Info(ctx)
+	if err != nil {
+		return err
+	}
+	d.defaultRuntime = info.DefaultRuntime
+	d.swarmActive = info.Swarm.LocalNodeState == swarm.LocalNodeStateActive || info.Swarm.LocalNodeState == swarm.LocalNodeStateLocked
+	// 协商后的版本要在第一次请求之后才能确定
+	d.apiVersion = DockerClient.ClientVersion()
+	d.serverVersion = version.Version
+	d.refreshedAt = time.Now()
+	return nil
+}
+
+// collectDaemon 输出daemon采集器的指标
+func (e *Exporter) collectDaemon(ch chan<- prometheus.Metric) error {
+	d := e.daemonInfo
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.refresh(context.Background()); err != nil {
+		return err
+	}
+	ch <- prometheus.MustNewConstMetric(e.apiVersionInfo, prometheus.GaugeValue, 1, d.apiVersion, d.serverVersion)
+	ch <- prometheus.MustNewConstMetric(e.defaultRuntimeInfo, prometheus.GaugeValue, 1, d.defaultRuntime)
+	ch <- prometheus.MustNewConstMetric(e.swarmActive, prometheus.GaugeValue, boolValue(d.swarmActive))
+	return nil
+}
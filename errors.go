@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+)
+
+// docker错误的分类，作为 container_exporter_scrape_errors_total 的 error_type
+const (
+	ErrorTypeConnection   = "connection"
+	ErrorTypeUnauthorized = "unauthorized"
+	ErrorTypeForbidden    = "forbidden"
+	ErrorTypeVersion      = "version"
+	ErrorTypeTimeout      = "timeout"
+	ErrorTypeOther        = "other"
+)
+
+// 每类错误对应的提示，便于直接从日志判断该怎么处理
+var errorTypeHints = map[string]string{
+	ErrorTypeConnection:   "cannot reach the docker daemon, check that it is running and --docker-host is correct",
+	ErrorTypeUnauthorized: "docker daemon rejected the credentials, check the TLS client certificate",
+	ErrorTypeForbidden:    "docker daemon denied the request, check the authz plugin rules",
+	ErrorTypeVersion:      "docker API version mismatch between the exporter and the daemon",
+	ErrorTypeTimeout:      "docker daemon did not answer in time",
+	ErrorTypeOther:        "docker API request failed",
+}
+
+// ClassifyDockerError 判断docker API错误的类型
+func ClassifyDockerError(err error) string {
+	var netErr net.Error
+	switch {
+	case errdefs.IsUnauthorized(err):
+		return ErrorTypeUnauthorized
+	case errdefs.IsForbidden(err):
+		return ErrorTypeForbidden
+	case errors.Is(err, context.DeadlineExceeded), errdefs.IsDeadline(err):
+		return ErrorTypeTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorTypeTimeout
+	case client.IsErrConnectionFailed(err), errors.As(err, &netErr):
+		return ErrorTypeConnection
+	case strings.Contains(strings.ToLower(err.Error()), "api version"),
+		strings.Contains(err.Error(), "client version"):
+		return ErrorTypeVersion
+	}
+	return ErrorTypeOther
+}
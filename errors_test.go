@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+)
+
+// timeoutError 模拟net包的超时错误
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyDockerError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{errdefs.Unauthorized(errors.New("bad certificate")), ErrorTypeUnauthorized},
+		{errdefs.Forbidden(errors.New("authorization denied by plugin")), ErrorTypeForbidden},
+		{context.DeadlineExceeded, ErrorTypeTimeout},
+		{fmt.Errorf("list containers: %w", context.DeadlineExceeded), ErrorTypeTimeout},
+		{errdefs.Deadline(errors.New("deadline")), ErrorTypeTimeout},
+		{timeoutError{}, ErrorTypeTimeout},
+		{errors.New("Error response from daemon: client version 1.41 is too new. Maximum supported API version is 1.40"), ErrorTypeVersion},
+		{errors.New("something else"), ErrorTypeOther},
+	}
+	for _, tt := range tests {
+		if got := ClassifyDockerError(tt.err); got != tt.want {
+			t.Errorf("ClassifyDockerError(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestClassifyDockerErrorFromDaemon(t *testing.T) {
+	for status, want := range map[int]string{
+		http.StatusUnauthorized:        ErrorTypeUnauthorized,
+		http.StatusForbidden:           ErrorTypeForbidden,
+		http.StatusInternalServerError: ErrorTypeOther,
+	} {
+		status := status
+		fakeDocker(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, `{"message":"denied"}`, status)
+		}))
+		_, err := DockerClient.ContainerList(context.Background(), types.ContainerListOptions{All: true})
+		if err == nil {
+			t.Fatalf("status %d: want error", status)
+		}
+		if got := ClassifyDockerError(err); got != want {
+			t.Errorf("status %d: ClassifyDockerError(%v) = %s, want %s", status, err, got, want)
+		}
+	}
+
+	// daemon没有响应时是超时
+	fakeDocker(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := DockerClient.ContainerList(ctx, types.ContainerListOptions{All: true})
+	if got := ClassifyDockerError(err); got != ErrorTypeTimeout {
+		t.Errorf("timeout: ClassifyDockerError(%v) = %s, want %s", err, got, ErrorTypeTimeout)
+	}
+
+	// 没有daemon监听时是连接错误
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://127.0.0.1:1"), client.WithVersion("1.41"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	_, err = cli.ContainerList(context.Background(), types.ContainerListOptions{All: true})
+	if got := ClassifyDockerError(err); got != ErrorTypeConnection {
+		t.Errorf("refused: ClassifyDockerError(%v) = %s, want %s", err, got, ErrorTypeConnection)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 事件流断开后重连的间隔
+const eventsRetryInterval = 5 * time.Second
+
+// EventSubscriber 订阅docker容器事件
+type EventSubscriber interface {
+	// Connected 每次(重新)订阅事件流成功后调用，返回错误时重新订阅
+	Connected(ctx context.Context) error
+	// Handle 处理一条容器事件
+	Handle(ctx context.Context, msg events.Message)
+	// Disconnected 事件流出错断开时调用
+	Disconnected(err error)
+}
+
+// WatchEvents 订阅docker容器事件并分发给所有subscriber，出错时等待后重连，ctx取消时返回
+func WatchEvents(ctx context.Context, subscribers ...EventSubscriber) {
+	for {
+		err := watchEvents(ctx, subscribers)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("docker event stream err, reconnect in %s, %v", eventsRetryInterval, err)
+		for _, subscriber := range subscribers {
+			subscriber.Disconnected(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(eventsRetryInterval):
+		}
+	}
+}
+
+func watchEvents(ctx context.Context, subscribers []EventSubscriber) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	msgs, errs := DockerClient.Events(ctx, types.EventsOptions{
+		Filters: filters.NewArgs(filters.Arg("type", events.ContainerEventType)),
+	})
+	// 先订阅再做全量同步，避免漏掉同步期间发生的事件
+	for _, subscriber := range subscribers {
+		if err := subscriber.Connected(ctx); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case msg := <-msgs:
+			for _, subscriber := range subscribers {
+				subscriber.Handle(ctx, msg)
+			}
+		case err := <-errs:
+			return err
+		}
+	}
+}
+
+// ContainerStore 事件驱动或后台轮询模式下在内存中维护的容器列表
+type ContainerStore struct {
+	mu         sync.RWMutex
+	containers map[string]types.Container
+	err        error
+	// 事件流断开的时间，此后列表不再更新
+	disconnectedAt time.Time
+	// 后台轮询模式下列表只在每次全量刷新时更新，polled为true时以refreshedAt作为容器最后被确认存在的时间
+	polled      bool
+	refreshedAt time.Time
+}
+
+func NewContainerStore() *ContainerStore {
+	return &ContainerStore{
+		containers: map[string]types.Container{},
+		err:        errors.New("waiting for the initial container list"),
+	}
+}
+
+// List 返回当前的容器列表，事件流断开期间同时返回断开的原因
+func (s *ContainerStore) List() ([]types.Container, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	containerList := make([]types.Container, 0, len(s.containers))
+	for _, container := range s.containers {
+		containerList = append(containerList, container)
+	}
+	return containerList, s.err
+}
+
+// SeenAt 返回列表中容器最后一次被确认存在的时间，轮询模式下为最近一次刷新成功的时间，
+// 事件流连接正常时为当前时间
+func (s *ContainerStore) SeenAt() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.polled {
+		return s.refreshedAt
+	}
+	if s.err != nil {
+		return s.disconnectedAt
+	}
+	return time.Now()
+}
+
+// Connected 全量拉取一次容器列表作为初始状态
+func (s *ContainerStore) Connected(ctx context.Context) error {
+	containerList, err := DockerClient.ContainerList(ctx, containerListOptions)
+	if err != nil {
+		return err
+	}
+	containers := make(map[string]types.Container, len(containerList))
+	for _, container := range containerList {
+		containers[container.ID] = container
+	}
+	s.mu.Lock()
+	s.containers = containers
+	s.err = nil
+	s.refreshedAt = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// Handle 根据事件刷新对应的容器，容器已不存在时删除
+func (s *ContainerStore) Handle(ctx context.Context, msg events.Message) {
+	// exec相关事件不会改变容器本身的状态
+	if strings.HasPrefix(msg.Action, "exec_") {
+		return
+	}
+	containerList, err := DockerClient.ContainerList(ctx, types.ContainerListOptions{
+		All:     containerListOptions.All,
+		Filters: filters.NewArgs(filters.Arg("id", msg.Actor.ID)),
+	})
+	if err != nil {
+		log.Printf("refresh container %s err, %v", msg.Actor.ID, err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.containers, msg.Actor.ID)
+	for _, container := range containerList {
+		s.containers[container.ID] = container
+	}
+}
+
+// Disconnected 记录断开原因，重连成功前的采集都会报告失败
+func (s *ContainerStore) Disconnected(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.disconnectedAt = time.Now()
+	}
+	s.err = err
+	s.mu.Unlock()
+}
+
+// 计数的容器事件，其余事件忽略以限制label的取值
+var countedEventActions = []string{"create", "start", "stop", "die", "oom", "kill"}
+
+// EventCounter 按action统计容器事件数量
+type EventCounter struct {
+	*prometheus.CounterVec
+}
+
+func NewEventCounter() *EventCounter {
+	counter := &EventCounter{prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:        "container_events_total",
+		Help:        "number of container events received from the docker event stream",
+		ConstLabels: constLabels,
+	}, []string{"action"})}
+	for _, action := range countedEventActions {
+		counter.WithLabelValues(action)
+	}
+	return counter
+}
+
+func (c *EventCounter) Connected(ctx context.Context) error {
+	return nil
+}
+
+func (c *EventCounter) Handle(ctx context.Context, msg events.Message) {
+	for _, action := range countedEventActions {
+		if msg.Action == action {
+			c.WithLabelValues(action).Inc()
+			return
+		}
+	}
+}
+
+func (c *EventCounter) Disconnected(err error) {}
+
+// RestartTracker 按容器名记录最近的重启时间，die之后再次start视为一次重启，
+// 按名称而不是id记录，容器被重建(新id)也能连续统计
+type RestartTracker struct {
+	window time.Duration
+	desc   *prometheus.Desc
+	now    func() time.Time
+
+	mu       sync.Mutex
+	died     map[string]time.Time
+	restarts map[string][]time.Time
+}
+
+func NewRestartTracker(window time.Duration) *RestartTracker {
+	return &RestartTracker{
+		window: window,
+		desc: prometheus.NewDesc(
+			"container_restarts_recent",
+			fmt.Sprintf("number of container restarts within the last %s", window),
+			[]string{"name"},
+			constLabels),
+		now:      time.Now,
+		died:     map[string]time.Time{},
+		restarts: map[string][]time.Time{},
+	}
+}
+
+func (t *RestartTracker) Connected(ctx context.Context) error {
+	return nil
+}
+
+func (t *RestartTracker) Handle(ctx context.Context, msg events.Message) {
+	name := msg.Actor.Attributes["name"]
+	if name == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch msg.Action {
+	case "die":
+		t.died[name] = t.now()
+	case "start":
+		// 窗口之前的die(例如容器被stop后很久才start)不算作一次重启
+		if diedAt, ok := t.died[name]; ok {
+			delete(t.died, name)
+			if diedAt.Before(t.now().Add(-t.window)) {
+				return
+			}
+			t.restarts[name] = append(t.restarts[name], t.now())
+		}
+	}
+}
+
+func (t *RestartTracker) Disconnected(err error) {}
+
+func (t *RestartTracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- t.desc
+}
+
+func (t *RestartTracker) Collect(ch chan<- prometheus.Metric) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	since := t.now().Add(-t.window)
+	// 只die没有再start的容器(已删除或停止)，超出窗口后不再记录
+	for name, diedAt := range t.died {
+		if diedAt.Before(since) {
+			delete(t.died, name)
+		}
+	}
+	for name, restarts := range t.restarts {
+		// 丢弃窗口之外的重启记录
+		i := 0
+		for i < len(restarts) && restarts[i].Before(since) {
+			i++
+		}
+		if restarts = restarts[i:]; len(restarts) == 0 {
+			delete(t.restarts, name)
+			continue
+		}
+		t.restarts[name] = restarts
+		ch <- prometheus.MustNewConstMetric(t.desc, prometheus.GaugeValue, float64(len(restarts)), name)
+	}
+}
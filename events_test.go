@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+)
+
+// containerEvent 构造一个容器事件
+func containerEvent(action, name string) events.Message {
+	return events.Message{
+		Type:   events.ContainerEventType,
+		Action: action,
+		Actor:  events.Actor{Attributes: map[string]string{"name": name}},
+	}
+}
+
+func TestRestartTrackerWindow(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	tracker := NewRestartTracker(10 * time.Minute)
+	tracker.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// web在窗口内重启3次
+	for i := 0; i < 3; i++ {
+		tracker.Handle(ctx, containerEvent("die", "web"))
+		now = now.Add(time.Minute)
+		tracker.Handle(ctx, containerEvent("start", "web"))
+	}
+	// db只die不start
+	tracker.Handle(ctx, containerEvent("die", "db"))
+
+	m := findMetric(gather(t, tracker)["container_restarts_recent"], map[string]string{"name": "web"})
+	if m == nil || metricValue(m) != 3 {
+		t.Fatalf("container_restarts_recent{name=web} = %v, want 3", m)
+	}
+
+	// 窗口过去后重启记录和未匹配的die都被清理
+	now = now.Add(11 * time.Minute)
+	if families := gather(t, tracker); families["container_restarts_recent"] != nil {
+		t.Errorf("container_restarts_recent should be empty after the window, got %v", families["container_restarts_recent"])
+	}
+	tracker.mu.Lock()
+	died, restarts := len(tracker.died), len(tracker.restarts)
+	tracker.mu.Unlock()
+	if died != 0 || restarts != 0 {
+		t.Errorf("after the window died=%d restarts=%d, want both 0", died, restarts)
+	}
+
+	// 窗口之前的die之后再start不算重启
+	tracker.Handle(ctx, containerEvent("die", "cache"))
+	now = now.Add(time.Hour)
+	tracker.Handle(ctx, containerEvent("start", "cache"))
+	if m := findMetric(gather(t, tracker)["container_restarts_recent"], map[string]string{"name": "cache"}); m != nil {
+		t.Errorf("start long after die counted as restart: %v", m)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectGPU 输出gpu采集器的指标，来自 --gpus 产生的DeviceRequests，没有申请GPU的容器数量为0
+func (e *Exporter) collectGPU(ch chan<- prometheus.Metric, container types.ContainerJSON, name string) {
+	if container.HostConfig == nil {
+		return
+	}
+	count := 0
+	for _, request := range container.HostConfig.DeviceRequests {
+		if !IsGPURequest(request) {
+			continue
+		}
+		switch {
+		case len(request.DeviceIDs) > 0:
+			count += len(request.DeviceIDs)
+		case request.Count < 0:
+			// --gpus all，实际数量取决于宿主机
+			count = -1
+		case count >= 0:
+			count += request.Count
+		}
+		capabilities := make([]string, 0, len(request.Capabilities))
+		for _, and := range request.Capabilities {
+			capabilities = append(capabilities, strings.Join(and, "&"))
+		}
+		ch <- prometheus.MustNewConstMetric(e.gpuInfo, prometheus.GaugeValue, 1, name, container.ID,
+			request.Driver, strings.Join(request.DeviceIDs, ","), strings.Join(capabilities, "|"))
+	}
+	ch <- prometheus.MustNewConstMetric(e.gpuCount, prometheus.GaugeValue, float64(count), name, container.ID)
+}
+
+// IsGPURequest 判断DeviceRequest是否申请GPU
+func IsGPURequest(request containertypes.DeviceRequest) bool {
+	if request.Driver == "nvidia" {
+		return true
+	}
+	for _, and := range request.Capabilities {
+		for _, capability := range and {
+			if capability == "gpu" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 健康状态与指标值的对应关系
+var healthStatusValues = map[string]float64{
+	types.Unhealthy: 0,
+	types.Healthy:   1,
+	types.Starting:  2,
+}
+
+// CheckFilterHealth 检查 --filter-health，basic为 /metrics 上的采集器。
+// health只在 --extended-collectors 中时，/metrics 的Exporter不会检查健康状态
+func CheckFilterHealth(filter string, basic map[string]bool) error {
+	if filter == "" {
+		return nil
+	}
+	if !basic["health"] {
+		return fmt.Errorf("--filter-health requires the health collector at /metrics, add --collect-health and keep it out of --extended-collectors")
+	}
+	if _, ok := healthStatusValues[filter]; !ok && filter != types.NoHealthcheck {
+		return fmt.Errorf("invalid --filter-health %q, must be healthy, unhealthy, starting or none", filter)
+	}
+	return nil
+}
+
+// HealthStatus 返回容器的健康状态，没有配置healthcheck时返回none
+func HealthStatus(container types.ContainerJSON) string {
+	if container.State == nil || container.State.Health == nil || container.State.Health.Status == "" {
+		return types.NoHealthcheck
+	}
+	return container.State.Health.Status
+}
+
+// collectHealth 输出health采集器的指标，没有healthcheck的容器不输出
+func (e *Exporter) collectHealth(ch chan<- prometheus.Metric, container types.ContainerJSON, name string) {
+	status := HealthStatus(container)
+	value, ok := healthStatusValues[status]
+	if !ok {
+		return
+	}
+	values := []string{name, container.ID, status}
+	if *healthOutputLength > 0 {
+		values = append(values, LastHealthOutput(container.State.Health, *healthOutputLength))
+	}
+	ch <- prometheus.MustNewConstMetric(e.healthStatus, prometheus.GaugeValue, value, values...)
+
+	if container.Config != nil && container.Config.Healthcheck != nil {
+		interval, timeout, retries, startPeriod := HealthcheckConfig(container.Config.Healthcheck)
+		ch <- prometheus.MustNewConstMetric(e.healthcheckConfigInfo, prometheus.GaugeValue, 1, name, container.ID,
+			interval.String(), timeout.String(), strconv.Itoa(retries), startPeriod.String())
+	}
+}
+
+// --health-output-length 的上限，label值过长会显著增加Prometheus的内存占用
+const maxHealthOutputLength = 256
+
+// LastHealthOutput 返回最后一次healthcheck的输出，换行等连续空白合并为一个空格，最多保留length个字符
+func LastHealthOutput(health *types.Health, length int) string {
+	if len(health.Log) == 0 {
+		return ""
+	}
+	output := []rune(strings.Join(strings.Fields(health.Log[len(health.Log)-1].Output), " "))
+	if len(output) > length {
+		output = output[:length]
+	}
+	return string(output)
+}
+
+// HealthcheckConfig 返回healthcheck实际生效的参数，未设置的参数使用docker的默认值
+func HealthcheckConfig(config *containertypes.HealthConfig) (interval, timeout time.Duration, retries int, startPeriod time.Duration) {
+	interval, timeout, retries, startPeriod = config.Interval, config.Timeout, config.Retries, config.StartPeriod
+	if interval == 0 {
+		interval = 30 * time.Second
+	}
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	if retries == 0 {
+		retries = 3
+	}
+	return
+}
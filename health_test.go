@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCheckFilterHealth(t *testing.T) {
+	tests := []struct {
+		filter string
+		basic  map[string]bool
+		ok     bool
+	}{
+		{"", map[string]bool{"state": true}, true},
+		{"healthy", map[string]bool{"state": true, "health": true}, true},
+		{"none", map[string]bool{"health": true}, true},
+		// health只在 --extended-collectors 中
+		{"healthy", map[string]bool{"state": true}, false},
+		{"sick", map[string]bool{"health": true}, false},
+	}
+	for _, tt := range tests {
+		if err := CheckFilterHealth(tt.filter, tt.basic); (err == nil) != tt.ok {
+			t.Errorf("CheckFilterHealth(%q, %v) = %v, want ok=%v", tt.filter, tt.basic, err, tt.ok)
+		}
+	}
+}
+
+func TestFilterHealthExtended(t *testing.T) {
+	setFlag(t, "filter-health", "healthy")
+	health := map[string]string{"aaa": "healthy", "bbb": "unhealthy"}
+	fakeDocker(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			io.WriteString(w, `[{"Id":"aaa","Names":["/web"],"Image":"nginx:1.21","State":"running","Status":"Up 1 hour"},
+				{"Id":"bbb","Names":["/api"],"Image":"nginx:1.21","State":"running","Status":"Up 1 hour"}]`)
+		case strings.HasSuffix(r.URL.Path, "/json"):
+			id := containerIDOf(r)
+			fmt.Fprintf(w, `{"Id":%q,"State":{"Status":"running","Running":true,"Health":{"Status":%q}}}`, id, health[id])
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	// /metrics 有health，/metrics/extended 只有images，两边都只输出健康的容器
+	basic := gather(t, testExporter("state", "health"))
+	extended := gather(t, testExporter("images"))
+	if runState := basic["container_run_state"]; runState == nil || len(runState.GetMetric()) != 1 || labelsOf(runState.GetMetric()[0])["name"] != "web" {
+		t.Errorf("container_run_state = %v, want only web", runState)
+	}
+	if m := findMetric(extended["container_image_usage_count"], map[string]string{"image": "nginx"}); m == nil || metricValue(m) != 1 {
+		t.Errorf("container_image_usage_count{image=nginx} = %v, want 1", m)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// setFlag 在测试期间修改命令行参数，结束后恢复
+func setFlag(tb testing.TB, name, value string) {
+	tb.Helper()
+	f := flag.Lookup(name)
+	if f == nil {
+		tb.Fatalf("unknown flag %s", name)
+	}
+	old := f.Value.String()
+	if err := flag.Set(name, value); err != nil {
+		tb.Fatalf("set flag %s: %v", name, err)
+	}
+	tb.Cleanup(func() { flag.Set(name, old) })
+}
+
+// useContainers 让GetContainerList返回给定的容器，不需要docker daemon
+func useContainers(tb testing.TB, containers ...types.Container) *ContainerStore {
+	tb.Helper()
+	store := NewContainerStore()
+	for _, container := range containers {
+		store.containers[container.ID] = container
+	}
+	store.err = nil
+	old := BackgroundStore
+	BackgroundStore = store
+	tb.Cleanup(func() { BackgroundStore = old })
+	return store
+}
+
+// fakeDocker 启动一个模拟docker daemon的HTTP服务，DockerClient在测试期间连接它
+func fakeDocker(tb testing.TB, handler http.Handler) {
+	tb.Helper()
+	server := httptest.NewServer(handler)
+	cli, err := client.NewClientWithOpts(
+		client.WithHost("tcp://"+strings.TrimPrefix(server.URL, "http://")),
+		client.WithVersion("1.41"),
+		client.WithHTTPClient(server.Client()))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	old := DockerClient
+	DockerClient = cli
+	tb.Cleanup(func() {
+		DockerClient = old
+		cli.Close()
+		server.Close()
+	})
+}
+
+// gather 注册到一个新的registry后采集一次，返回指标名到指标族的映射
+func gather(tb testing.TB, collectors ...prometheus.Collector) map[string]*dto.MetricFamily {
+	tb.Helper()
+	reg := prometheus.NewPedanticRegistry()
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			tb.Fatalf("register: %v", err)
+		}
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		tb.Fatalf("gather: %v", err)
+	}
+	result := make(map[string]*dto.MetricFamily, len(families))
+	for _, family := range families {
+		result[family.GetName()] = family
+	}
+	return result
+}
+
+// labelsOf 返回指标的label
+func labelsOf(m *dto.Metric) map[string]string {
+	labels := make(map[string]string, len(m.GetLabel()))
+	for _, pair := range m.GetLabel() {
+		labels[pair.GetName()] = pair.GetValue()
+	}
+	return labels
+}
+
+// findMetric 返回指标族中label包含want的第一个指标，没有时返回nil
+func findMetric(family *dto.MetricFamily, want map[string]string) *dto.Metric {
+	if family == nil {
+		return nil
+	}
+	for _, m := range family.GetMetric() {
+		labels := labelsOf(m)
+		matched := true
+		for name, value := range want {
+			if labels[name] != value {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return m
+		}
+	}
+	return nil
+}
+
+// metricValue 返回gauge、counter或untyped指标的值
+func metricValue(m *dto.Metric) float64 {
+	switch {
+	case m.GetGauge() != nil:
+		return m.GetGauge().GetValue()
+	case m.GetCounter() != nil:
+		return m.GetCounter().GetValue()
+	default:
+		return m.GetUntyped().GetValue()
+	}
+}
+
+// testExporter 使用空配置和给定采集器创建Exporter
+func testExporter(collectors ...string) *Exporter {
+	enabled := make(map[string]bool, len(collectors))
+	for _, name := range collectors {
+		enabled[name] = true
+	}
+	return NewExporter(&Config{}, enabled)
+}
+
+// containerIDOf 返回 /containers/<id>/json 这类请求路径中的容器id
+func containerIDOf(r *http.Request) string {
+	path := r.URL.Path[strings.Index(r.URL.Path, "/containers/")+len("/containers/"):]
+	if i := strings.Index(path, "/"); i >= 0 {
+		path = path[:i]
+	}
+	return path
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ImageCache 按镜像id跨采集缓存image inspect的结果和从镜像名解析出的仓库、版本，多个容器使用同一镜像时只查询一次。
+// 镜像id由内容决定，镜像更新后id随之变化，旧的缓存项不再被使用，最终被淘汰。
+// 每次采集开始时调用Begin，按最近使用的顺序淘汰到size个，所以一次采集中缓存项可以超过size；
+// 查询失败的镜像只在本次采集中缓存，下一次采集重新查询
+type ImageCache struct {
+	size int
+
+	mu      sync.Mutex
+	scrape  uint64
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type imageEntry struct {
+	id      string
+	inspect *types.ImageInspect
+	// inspect失败时所在的采集
+	failedScrape uint64
+	// 解析repository和version时使用的镜像名，同一id以不同镜像名引用时重新解析
+	image      string
+	repository string
+	version    string
+}
+
+// NewImageCache size为0时每次采集都重新查询
+func NewImageCache(size int) *ImageCache {
+	return &ImageCache{size: size, entries: map[string]*list.Element{}, lru: list.New()}
+}
+
+// Begin 开始一次采集，淘汰多余的缓存项
+func (c *ImageCache) Begin() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scrape++
+	for c.lru.Len() > c.size {
+		entry := c.lru.Remove(c.lru.Back()).(*imageEntry)
+		delete(c.entries, entry.id)
+	}
+}
+
+// entry 返回镜像id对应的缓存项并标记为最近使用，调用方需持有锁
+func (c *ImageCache) entry(id string) *imageEntry {
+	if elem, ok := c.entries[id]; ok {
+		c.lru.MoveToFront(elem)
+		return elem.Value.(*imageEntry)
+	}
+	entry := &imageEntry{id: id}
+	c.entries[id] = c.lru.PushFront(entry)
+	return entry
+}
+
+// Inspect 返回容器所用镜像的inspect结果，失败时结果为nil，只有本次调用查询失败时才返回错误，
+// 本次采集中已经失败过的镜像不会重复返回错误
+func (c *ImageCache) Inspect(info types.Container) (*types.ImageInspect, error) {
+	c.mu.Lock()
+	entry := c.entry(info.ImageID)
+	image, failed := entry.inspect, entry.failedScrape == c.scrape
+	c.mu.Unlock()
+	if image != nil || failed {
+		return image, nil
+	}
+	// 查询时不持有锁，并发的采集可能重复查询同一镜像，结果相同
+	inspect, _, err := DockerClient.ImageInspectWithRaw(context.Background(), info.ImageID)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		errorLog.Printf("inspect-image:"+info.ImageID, "inspect image %s err, %v", info.Image, err)
+		entry.failedScrape = c.scrape
+		return nil, err
+	}
+	entry.inspect = &inspect
+	return entry.inspect, nil
+}
+
+// Version 返回镜像名中的仓库和GetContainerVersion得到的版本
+func (c *ImageCache) Version(imageID, image string) (repository, version string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.entry(imageID)
+	if entry.image != image || entry.repository == "" {
+		entry.image = image
+		entry.repository, _ = ParseImage(image)
+		entry.version = GetContainerVersion(image)
+	}
+	return entry.repository, entry.version
+}
+
+// collectImagePlatform 输出容器镜像的os和架构，用于发现通过模拟运行的其他架构镜像
+func (e *Exporter) collectImagePlatform(ch chan<- prometheus.Metric, info types.Container, name string, images *ImageCache) {
+	image, err := images.Inspect(info)
+	if err != nil {
+		e.collectorErrors.WithLabelValues("image-platform").Inc()
+	}
+	if image == nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(e.imagePlatformInfo, prometheus.GaugeValue, 1, name, info.ID, info.Image, image.Os, image.Architecture, image.Variant)
+}
+
+// ParseImage 拆分镜像名中的仓库和tag，去掉@sha256:...形式的digest，
+// 没有tag时返回latest，例如 registry:5000/app:1.2 返回 registry:5000/app 和 1.2
+func ParseImage(image string) (repository, tag string) {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	// 冒号出现在最后一个/之前时是registry的端口，不是tag
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], image[i+1:]
+	}
+	return image, "latest"
+}
+
+// ImageRegistry 返回镜像所在registry的地址，与docker的规则一致：第一段包含.或:或者是localhost时是registry，
+// 否则是Docker Hub，例如 ghcr.io/org/app:1 返回 ghcr.io，registry:5000/app 返回 registry:5000，nginx 和 library/nginx 返回 docker.io
+func ImageRegistry(image string) string {
+	if image == "" {
+		return ""
+	}
+	repository, _ := ParseImage(image)
+	i := strings.Index(repository, "/")
+	if i < 0 {
+		return "docker.io"
+	}
+	if host := repository[:i]; strings.ContainsAny(host, ".:") || host == "localhost" {
+		return host
+	}
+	return "docker.io"
+}
+
+// versionRegexp --version-regex 编译后的结果，为nil时使用tag作为版本
+var versionRegexp *regexp.Regexp
+
+// CompileVersionRegex 编译 --version-regex，必须包含提取版本的捕获组
+func CompileVersionRegex(expr string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	if re.NumSubexp() < 1 {
+		return nil, fmt.Errorf("regex %q has no capture group for the version", expr)
+	}
+	return re, nil
+}
+
+// GetContainerVersion 返回镜像的版本，设置了 --version-regex 时取第一个捕获组，
+// 例如 ^.*/app-([0-9.]+):.*$ 从 registry/app-1.2:build5 中取出1.2，不匹配或没有设置时返回tag。
+// 只通过digest引用、没有tag的镜像(如 app@sha256:0123...)返回digest的前12位，同时有tag和digest时返回tag
+func GetContainerVersion(image string) string {
+	if versionRegexp != nil {
+		if m := versionRegexp.FindStringSubmatch(image); m != nil {
+			return m[1]
+		}
+	}
+	if i := strings.Index(image, "@"); i >= 0 {
+		name, digest := image[:i], image[i+1:]
+		if j := strings.LastIndex(name, ":"); j <= strings.LastIndex(name, "/") {
+			return ShortDigest(digest)
+		}
+	}
+	_, tag := ParseImage(image)
+	return tag
+}
+
+// ShortDigest 去掉digest的算法前缀，返回前12位，与docker显示的短id一致
+func ShortDigest(digest string) string {
+	if i := strings.Index(digest, ":"); i >= 0 {
+		digest = digest[i+1:]
+	}
+	if len(digest) > 12 {
+		digest = digest[:12]
+	}
+	return digest
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// useVersionRegex 在测试期间设置 --version-regex
+func useVersionRegex(t *testing.T, expr string) {
+	t.Helper()
+	re, err := CompileVersionRegex(expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := versionRegexp
+	versionRegexp = re
+	t.Cleanup(func() { versionRegexp = old })
+}
+
+func TestGetContainerVersionRegex(t *testing.T) {
+	tests := []struct {
+		regex string
+		image string
+		want  string
+	}{
+		// 版本在镜像名中
+		{`^.*/app-([0-9.]+):.*$`, "registry/app-1.2:build5", "1.2"},
+		// tag带有构建号，只取语义化版本
+		{`:v?([0-9]+\.[0-9]+\.[0-9]+)`, "ghcr.io/org/api:v2.3.4-build.17", "2.3.4"},
+		// tag为 分支-版本-提交
+		{`:[a-z]+-([0-9.]+)-[0-9a-f]+$`, "registry:5000/team/web:main-1.8.0-3f2a1bc", "1.8.0"},
+		// 命名捕获组同样取第一个捕获组
+		{`:(?P<version>[0-9]{8})\.[0-9]+$`, "app:20240131.4", "20240131"},
+		// 不匹配时使用tag
+		{`^.*/app-([0-9.]+):.*$`, "nginx:1.21", "1.21"},
+		{`:v?([0-9]+\.[0-9]+\.[0-9]+)`, "redis", "latest"},
+	}
+	for _, tt := range tests {
+		useVersionRegex(t, tt.regex)
+		if got := GetContainerVersion(tt.image); got != tt.want {
+			t.Errorf("with regex %q GetContainerVersion(%q) = %q, want %q", tt.regex, tt.image, got, tt.want)
+		}
+	}
+}
+
+func TestCompileVersionRegex(t *testing.T) {
+	for _, expr := range []string{`app-[0-9.]+`, `(`} {
+		if _, err := CompileVersionRegex(expr); err == nil {
+			t.Errorf("CompileVersionRegex(%q) should fail", expr)
+		}
+	}
+}
+
+// fakeImages 模拟image inspect接口，返回统计请求次数的计数器
+func fakeImages(tb testing.TB) *int32 {
+	var inspects int32
+	fakeDocker(tb, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, "/images/") {
+			http.NotFound(w, r)
+			return
+		}
+		atomic.AddInt32(&inspects, 1)
+		io.WriteString(w, `{"Id":"sha256:0","Os":"linux","Architecture":"amd64","Created":"2024-01-31T00:00:00Z"}`)
+	}))
+	return &inspects
+}
+
+func TestImageCacheAcrossScrapes(t *testing.T) {
+	useContainers(t, benchContainers(100)...)
+	for _, tt := range []struct {
+		size string
+		want int32
+	}{
+		// 10个镜像，缓存时只在第一次采集查询
+		{"256", 10},
+		// 不缓存时每次采集每个镜像查询一次
+		{"0", 30},
+	} {
+		inspects := fakeImages(t)
+		setFlag(t, "image-cache-size", tt.size)
+		e := testExporter("state", "images", "image-platform")
+		for i := 0; i < 3; i++ {
+			gather(t, e)
+		}
+		if got := atomic.LoadInt32(inspects); got != tt.want {
+			t.Errorf("--image-cache-size=%s: %d image inspects in 3 scrapes, want %d", tt.size, got, tt.want)
+		}
+	}
+}
+
+func BenchmarkCollectImageCache(b *testing.B) {
+	useContainers(b, benchContainers(1000)...)
+	for _, size := range []string{"256", "0"} {
+		name := "cached"
+		if size == "0" {
+			name = "uncached"
+		}
+		b.Run(name, func(b *testing.B) {
+			inspects := fakeImages(b)
+			setFlag(b, "image-cache-size", size)
+			e := testExporter("state", "images", "image-platform")
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				drain(e)
+			}
+			b.ReportMetric(float64(atomic.LoadInt32(inspects))/float64(b.N), "inspects/op")
+		})
+	}
+}
+
+func TestGetContainerVersionDigest(t *testing.T) {
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{"nginx:1.21", "1.21"},
+		{"registry:5000/team/app:2.0", "2.0"},
+		{"nginx", "latest"},
+		// 只有digest时返回digest的前12位
+		{"nginx@" + digest, "0123456789ab"},
+		{"registry:5000/team/app@" + digest, "0123456789ab"},
+		// 同时有tag和digest时返回tag
+		{"nginx:1.21@" + digest, "1.21"},
+		{"registry:5000/team/app:2.0@" + digest, "2.0"},
+	}
+	for _, tt := range tests {
+		if got := GetContainerVersion(tt.image); got != tt.want {
+			t.Errorf("GetContainerVersion(%q) = %q, want %q", tt.image, got, tt.want)
+		}
+		// 仓库中不包含tag和digest
+		if repository, _ := ParseImage(tt.image); strings.ContainsAny(repository[strings.LastIndex(repository, "/")+1:], ":@") {
+			t.Errorf("ParseImage(%q) repository = %q", tt.image, repository)
+		}
+	}
+}
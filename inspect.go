@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 需要inspect容器的采集器
+var inspectCollectors = []string{"inspect", "image-age", "network-info", "gpu", "health", "stats", "log-size"}
+
+// needInspect 启用了任意一个需要inspect的采集器或 --filter-health 时返回true，
+// /metrics/extended 没有health采集器时也需要inspect才能按健康状态过滤
+func (e *Exporter) needInspect() bool {
+	if atomic.LoadInt32(&e.inspectDenied) == 1 {
+		return false
+	}
+	if *filterHealth != "" {
+		return true
+	}
+	for _, name := range inspectCollectors {
+		if e.collectors[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// inspect 启用了需要inspect的采集器时inspect容器，失败或不需要时ok为false
+func (e *Exporter) inspect(info types.Container, name string) (container types.ContainerJSON, ok bool) {
+	if !e.needInspect() {
+		return container, false
+	}
+	container, err := DockerClient.ContainerInspect(context.Background(), info.ID)
+	if errdefs.IsForbidden(err) {
+		// authz插件可能只允许list不允许inspect，此时不再每次采集都报错，本次运行期间停用inspect
+		if atomic.CompareAndSwapInt32(&e.inspectDenied, 0, 1) {
+			log.Printf("inspect container is forbidden by the docker daemon, disable the inspect based collectors, %v", err)
+		}
+		return container, false
+	}
+	if errdefs.IsNotFound(err) {
+		// 列出容器之后、inspect之前容器已被删除，容器频繁创建删除时很常见，本次只输出不需要inspect的指标
+		debugLog("container %s was removed before inspecting it, %v", name, err)
+		return container, false
+	}
+	if err != nil {
+		errorLog.Printf("inspect:"+info.ID, "inspect container %s err, %v", name, err)
+		for _, collector := range inspectCollectors {
+			if e.collectors[collector] {
+				e.collectorErrors.WithLabelValues(collector).Inc()
+			}
+		}
+		return container, false
+	}
+	return container, true
+}
+
+// collectInspect 输出需要inspect容器才能拿到的指标，images缓存已查询过的镜像，restarts累计本次采集的重启次数
+func (e *Exporter) collectInspect(ch chan<- prometheus.Metric, info types.Container, container types.ContainerJSON, name string, images *ImageCache, restarts *RestartScrape) {
+	if e.collectors["inspect"] {
+		e.collectDetails(ch, container, name, restarts)
+	}
+	if e.collectors["network-info"] {
+		e.collectNetworkInfo(ch, container, name)
+	}
+	if e.collectors["gpu"] {
+		e.collectGPU(ch, container, name)
+	}
+	if e.collectors["health"] {
+		e.collectHealth(ch, container, name)
+	}
+	if e.collectors["image-age"] {
+		e.collectImageAge(ch, info, container, name, images)
+	}
+	if e.collectors["log-size"] {
+		e.collectLogSize(ch, container, name)
+	}
+}
+
+// collectImageAge 输出镜像创建时间以及容器启动时镜像已经创建了多久
+func (e *Exporter) collectImageAge(ch chan<- prometheus.Metric, info types.Container, container types.ContainerJSON, name string, images *ImageCache) {
+	image, err := images.Inspect(info)
+	if err != nil {
+		e.collectorErrors.WithLabelValues("image-age").Inc()
+	}
+	if image == nil {
+		return
+	}
+	created, _ := ParseDockerTime(image.Created)
+	if created.IsZero() {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(e.imageCreatedTime, prometheus.GaugeValue, float64(created.Unix()), name, info.ID, info.Image)
+	if startedAt, _ := ParseDockerTime(container.State.StartedAt); !startedAt.IsZero() {
+		ch <- prometheus.MustNewConstMetric(e.imageStaleness, prometheus.GaugeValue, startedAt.Sub(created).Seconds(), name, info.ID, info.Image)
+	}
+}
+
+// collectDetails 输出inspect采集器的指标
+func (e *Exporter) collectDetails(ch chan<- prometheus.Metric, container types.ContainerJSON, name string, restarts *RestartScrape) {
+	if startedAt, _ := ParseDockerTime(container.State.StartedAt); !startedAt.IsZero() {
+		ch <- prometheus.MustNewConstMetric(e.startTime, prometheus.GaugeValue, float64(startedAt.Unix()), name, container.ID)
+	}
+
+	ch <- prometheus.MustNewConstMetric(e.unlimited, prometheus.GaugeValue, Unlimited(container.HostConfig), name, container.ID)
+
+	if container.Config != nil {
+		ch <- prometheus.MustNewConstMetric(e.runAsRoot, prometheus.GaugeValue, RunAsRoot(container.Config.User), name, container.ID)
+	}
+
+	if total, ok := restarts.Observe(name, container.ID, container.RestartCount); ok {
+		ch <- prometheus.MustNewConstMetric(e.restartsSinceStart, prometheus.CounterValue, float64(total), name)
+	}
+
+	// docker只在按重启策略重启时增加RestartCount，手动docker start/restart会把它清零，
+	// 所以RestartCount大于0说明上一次手动启动之后至少被策略重启过一次。
+	// daemon重启时按策略拉起的容器不增加RestartCount，会被当作手动启动
+	ch <- prometheus.MustNewConstMetric(e.restartedByPolicy, prometheus.GaugeValue, boolValue(container.RestartCount > 0), name, container.ID)
+
+	if container.HostConfig != nil {
+		policy := container.HostConfig.RestartPolicy
+		ch <- prometheus.MustNewConstMetric(e.restartMaxRetries, prometheus.GaugeValue, float64(policy.MaximumRetryCount), name, container.ID, policy.Name)
+	}
+
+	if container.State != nil && (container.State.Status == "exited" || container.State.Status == "dead") {
+		if finishedAt, _ := ParseDockerTime(container.State.FinishedAt); !finishedAt.IsZero() && time.Since(finishedAt) <= *recentlyExitedWindow {
+			ch <- prometheus.MustNewConstMetric(e.recentlyExited, prometheus.GaugeValue, float64(finishedAt.Unix()), name, container.ID, strconv.Itoa(container.State.ExitCode))
+		}
+	}
+
+	if container.State != nil && container.State.Restarting {
+		ch <- prometheus.MustNewConstMetric(e.restartBackoff, prometheus.GaugeValue, RestartBackoff(container.RestartCount).Seconds(), name, container.ID)
+		if finishedAt, _ := ParseDockerTime(container.State.FinishedAt); !finishedAt.IsZero() {
+			ch <- prometheus.MustNewConstMetric(e.restartingDuration, prometheus.GaugeValue, time.Since(finishedAt).Seconds(), name, container.ID)
+		}
+	}
+
+	if container.HostConfig != nil {
+		ch <- prometheus.MustNewConstMetric(e.runtimeInfo, prometheus.GaugeValue, 1, name, container.ID, container.HostConfig.Runtime)
+	}
+
+	graphDriverValues := []string{name, container.ID, container.GraphDriver.Name}
+	if *graphDriverPaths {
+		graphDriverValues = append(graphDriverValues, container.GraphDriver.Data["UpperDir"], container.GraphDriver.Data["MergedDir"])
+	}
+	ch <- prometheus.MustNewConstMetric(e.graphDriverInfo, prometheus.GaugeValue, 1, graphDriverValues...)
+
+	logDriver := ""
+	if container.HostConfig != nil {
+		logDriver = container.HostConfig.LogConfig.Type
+	}
+	infoValues := []string{name, container.ID, container.Image, logDriver}
+	if container.Config != nil {
+		infoValues = append(infoValues, ImageRegistry(container.Config.Image))
+		infoValues = append(infoValues, ConfiguredHostname(container.ID, container.Config.Hostname), container.Config.Domainname)
+		infoValues = append(infoValues, EnvValues(container.Config.Env, e.exposedEnv)...)
+	} else {
+		infoValues = append(infoValues, make([]string, 3+len(e.exposedEnv))...)
+	}
+	ch <- prometheus.MustNewConstMetric(e.containerInfo, prometheus.GaugeValue, 1, infoValues...)
+
+	if container.HostConfig != nil {
+		seccomp, apparmor := SecurityProfiles(container.HostConfig, container.AppArmorProfile)
+		ch <- prometheus.MustNewConstMetric(e.defaultSeccomp, prometheus.GaugeValue, boolValue(seccomp == "default"), name, container.ID)
+		ch <- prometheus.MustNewConstMetric(e.securityInfo, prometheus.GaugeValue, 1, name, container.ID, seccomp, apparmor)
+	}
+
+	if container.HostConfig != nil {
+		capAdd := NormalizeCapabilities(container.HostConfig.CapAdd)
+		capDrop := NormalizeCapabilities(container.HostConfig.CapDrop)
+		ch <- prometheus.MustNewConstMetric(e.capabilitiesInfo, prometheus.GaugeValue, 1, name, container.ID, strings.Join(capAdd, ","), strings.Join(capDrop, ","))
+		dangerous := container.HostConfig.Privileged || HasCapability(capAdd, e.dangerousCaps)
+		ch <- prometheus.MustNewConstMetric(e.dangerousCap, prometheus.GaugeValue, boolValue(dangerous), name, container.ID)
+
+		// NetworkMode.IsHost在windows上编译时总是返回false，直接比较字符串
+		ch <- prometheus.MustNewConstMetric(e.sharesHostPid, prometheus.GaugeValue, boolValue(container.HostConfig.PidMode == "host"), name, container.ID)
+		ch <- prometheus.MustNewConstMetric(e.sharesHostIpc, prometheus.GaugeValue, boolValue(container.HostConfig.IpcMode == "host"), name, container.ID)
+		ch <- prometheus.MustNewConstMetric(e.sharesHostNetwork, prometheus.GaugeValue, boolValue(container.HostConfig.NetworkMode == "host"), name, container.ID)
+
+		if cpuset := container.HostConfig.CpusetCpus; cpuset == "" {
+			ch <- prometheus.MustNewConstMetric(e.cpusetCount, prometheus.GaugeValue, 0, name, container.ID)
+		} else if count, err := CPUSetCount(cpuset); err == nil {
+			ch <- prometheus.MustNewConstMetric(e.cpusetInfo, prometheus.GaugeValue, 1, name, container.ID, cpuset)
+			ch <- prometheus.MustNewConstMetric(e.cpusetCount, prometheus.GaugeValue, float64(count), name, container.ID)
+		}
+
+		// 没有设置时为0，cgroup使用内核的默认权重
+		cpuShares, blkioWeight := container.HostConfig.CPUShares, container.HostConfig.BlkioWeight
+		if cpuShares == 0 {
+			cpuShares = defaultCPUShares
+		}
+		if blkioWeight == 0 {
+			blkioWeight = defaultBlkioWeight
+		}
+		ch <- prometheus.MustNewConstMetric(e.cpuShares, prometheus.GaugeValue, float64(cpuShares), name, container.ID)
+		ch <- prometheus.MustNewConstMetric(e.blkioWeight, prometheus.GaugeValue, float64(blkioWeight), name, container.ID)
+
+		// 没有设置的ulimit继承daemon的默认值，inspect中看不到，不输出
+		for _, ulimit := range container.HostConfig.Ulimits {
+			ch <- prometheus.MustNewConstMetric(e.ulimitSoft, prometheus.GaugeValue, float64(ulimit.Soft), name, container.ID, ulimit.Name)
+			ch <- prometheus.MustNewConstMetric(e.ulimitHard, prometheus.GaugeValue, float64(ulimit.Hard), name, container.ID, ulimit.Name)
+		}
+	}
+}
+
+// cgroup v1中cpu.shares和blkio.weight的默认值
+const (
+	defaultCPUShares   = 1024
+	defaultBlkioWeight = 500
+)
+
+// CPUSetCount 计算cpuset中CPU的数量，格式与 --cpuset-cpus 相同，例如 0-3,8
+func CPUSetCount(cpuset string) (int, error) {
+	count := 0
+	for _, part := range strings.Split(cpuset, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(part), "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return 0, fmt.Errorf("invalid cpuset %q", cpuset)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil || last < first {
+				return 0, fmt.Errorf("invalid cpuset %q", cpuset)
+			}
+		}
+		count += last - first + 1
+	}
+	return count, nil
+}
+
+// ConfiguredHostname 返回通过 --hostname 指定的主机名，没有指定时docker使用容器id的前12位，
+// 与id label重复，返回空字符串
+func ConfiguredHostname(id, hostname string) string {
+	if len(id) >= 12 && hostname == id[:12] {
+		return ""
+	}
+	return hostname
+}
+
+// RestartBackoff 估算docker重启前等待的时间，与docker的restartmanager一致：从100ms开始每次重启翻倍，最多1分钟。
+// 容器运行超过10s后docker会把等待时间重置，而RestartCount不会，所以对偶尔重启的容器会偏大
+func RestartBackoff(restartCount int) time.Duration {
+	backoff := 100 * time.Millisecond
+	for i := 1; i < restartCount && backoff < time.Minute; i++ {
+		backoff *= 2
+	}
+	if backoff > time.Minute {
+		backoff = time.Minute
+	}
+	return backoff
+}
+
+// SecurityProfiles 从SecurityOpt解析seccomp和apparmor配置，seccomp返回default、unconfined或custom，
+// apparmor没有在SecurityOpt中指定时使用docker实际应用的profile
+func SecurityProfiles(hostConfig *containertypes.HostConfig, appArmorProfile string) (seccomp, apparmor string) {
+	seccomp, apparmor = "default", appArmorProfile
+	if hostConfig.Privileged {
+		seccomp = "unconfined"
+	}
+	for _, opt := range hostConfig.SecurityOpt {
+		// 兼容旧的 seccomp:unconfined 写法
+		i := strings.IndexAny(opt, "=:")
+		if i < 0 {
+			continue
+		}
+		key, value := opt[:i], opt[i+1:]
+		switch key {
+		case "seccomp":
+			switch value {
+			case "unconfined":
+				seccomp = "unconfined"
+			case "builtin":
+				seccomp = "default"
+			default:
+				// docker命令行会把profile文件的内容直接放进来
+				seccomp = "custom"
+			}
+		case "apparmor":
+			apparmor = value
+		}
+	}
+	return seccomp, apparmor
+}
+
+// NormalizeCapabilities 统一为不带CAP_前缀的大写形式并排序
+func NormalizeCapabilities(caps []string) []string {
+	normalized := make([]string, 0, len(caps))
+	for _, c := range caps {
+		normalized = append(normalized, strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(c)), "CAP_"))
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// HasCapability 添加的capability中包含任意一个指定的capability或ALL时返回true
+func HasCapability(capAdd []string, caps []string) bool {
+	for _, added := range capAdd {
+		if added == "ALL" {
+			return true
+		}
+		for _, c := range caps {
+			if added == c {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RunAsRoot 容器以root运行时返回1，User支持 user、uid、user:group、uid:gid 的形式，为空时docker默认使用root
+func RunAsRoot(user string) float64 {
+	user = strings.TrimSpace(user)
+	if i := strings.Index(user, ":"); i >= 0 {
+		user = user[:i]
+	}
+	if uid, err := strconv.Atoi(user); err == nil {
+		return boolValue(uid == 0)
+	}
+	return boolValue(user == "" || user == "root")
+}
+
+// Unlimited 既没有内存限制也没有CPU限制时返回1
+func Unlimited(hostConfig *containertypes.HostConfig) float64 {
+	if hostConfig == nil {
+		return 1
+	}
+	if hostConfig.Memory > 0 || hostConfig.NanoCPUs > 0 || hostConfig.CPUQuota > 0 {
+		return 0
+	}
+	return 1
+}
+
+// 名称包含这些关键字的环境变量视为敏感信息，即使在 --expose-env 中也不输出
+var secretEnvPatterns = []string{"PASSWORD", "SECRET", "TOKEN"}
+
+// ExposableEnv 过滤掉 --expose-env 中的敏感环境变量，重复的去掉，
+// 不同的环境变量对应同一个label名称时(例如 APP.VER 和 APP_VER 都是 env_app_ver)返回错误
+func ExposableEnv(names []string) ([]string, error) {
+	exposable := make([]string, 0, len(names))
+	labelOf := make(map[string]string, len(names))
+	for _, name := range names {
+		label := EnvLabelName(name)
+		if other, ok := labelOf[label]; ok {
+			if other == name {
+				continue
+			}
+			return nil, fmt.Errorf("environment variables %s and %s are both exposed as label %s", other, name, label)
+		}
+		labelOf[label] = name
+		secret := false
+		for _, pattern := range secretEnvPatterns {
+			if strings.Contains(strings.ToUpper(name), pattern) {
+				secret = true
+				break
+			}
+		}
+		if secret {
+			log.Printf("environment variable %s looks like a secret, it will not be exposed", name)
+			continue
+		}
+		exposable = append(exposable, name)
+	}
+	return exposable, nil
+}
+
+// EnvLabelName 返回环境变量对应的label名称，例如 APP_VERSION 对应 env_app_version
+func EnvLabelName(name string) string {
+	label := []byte("env_" + strings.ToLower(name))
+	for i, c := range label {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_') {
+			label[i] = '_'
+		}
+	}
+	return string(label)
+}
+
+// EnvValues 按names的顺序返回容器中对应环境变量的值，没有设置的为空
+func EnvValues(env []string, names []string) []string {
+	values := make([]string, len(names))
+	for _, kv := range env {
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			continue
+		}
+		for j, name := range names {
+			if kv[:i] == name {
+				values[j] = kv[i+1:]
+			}
+		}
+	}
+	return values
+}
+
+// boolValue 将bool转换为指标值
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// ParseDockerTime 解析docker返回的RFC3339时间，docker用0001-01-01表示未设置，此时返回零值
+func ParseDockerTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil || t.Year() <= 1 {
+		return time.Time{}, err
+	}
+	return t, nil
+}
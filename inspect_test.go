@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExposableEnv(t *testing.T) {
+	got, err := ExposableEnv([]string{"APP_VER", "DB_PASSWORD", "APP_VER", "REGION", "api_token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"APP_VER", "REGION"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ExposableEnv = %v, want %v", got, want)
+	}
+
+	// 不同的环境变量对应同一个label
+	for _, names := range [][]string{{"APP.VER", "APP_VER"}, {"app_ver", "APP_VER"}, {"APP-VER", "APP.VER"}} {
+		_, err := ExposableEnv(names)
+		if err == nil || !strings.Contains(err.Error(), "env_app_ver") {
+			t.Errorf("ExposableEnv(%v) = %v, want a collision error naming env_app_ver", names, err)
+		}
+	}
+}
+
+func TestInspectForbidden(t *testing.T) {
+	var inspects int32
+	fakeDocker(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			io.WriteString(w, `[{"Id":"aaa","Names":["/web"],"Image":"nginx:1.21","State":"running","Status":"Up 1 hour"}]`)
+		case strings.HasSuffix(r.URL.Path, "/json"):
+			// authz插件只允许list
+			atomic.AddInt32(&inspects, 1)
+			http.Error(w, `{"message":"authorization denied by plugin opa: container inspect not allowed"}`, http.StatusForbidden)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	e := testExporter("state", "inspect")
+
+	for i := 0; i < 3; i++ {
+		families := gather(t, e)
+		// 不需要inspect的指标照常输出，需要inspect的指标不输出
+		if m := findMetric(families["container_run_state"], map[string]string{"name": "web"}); m == nil || metricValue(m) != 1 {
+			t.Fatalf("scrape %d: container_run_state{name=web} = %v, want 1", i, m)
+		}
+		if families["container_start_time_seconds"] != nil {
+			t.Errorf("scrape %d: container_start_time_seconds should not be reported without inspect", i)
+		}
+		// 被拒绝不算采集失败
+		if m := findMetric(families["container_exporter_scrape_success"], nil); m == nil || metricValue(m) != 1 {
+			t.Errorf("scrape %d: container_exporter_scrape_success = %v, want 1", i, m)
+		}
+	}
+	// 第一次被拒绝后本次运行期间不再inspect
+	if n := atomic.LoadInt32(&inspects); n != 1 {
+		t.Errorf("inspected %d times, want 1", n)
+	}
+}
+
+func TestRecentlyExited(t *testing.T) {
+	now := time.Now().UTC()
+	states := map[string]string{
+		"aaa": fmt.Sprintf(`{"Status":"exited","ExitCode":1,"FinishedAt":%q}`, now.Add(-2*time.Minute).Format(time.RFC3339Nano)),
+		"bbb": fmt.Sprintf(`{"Status":"exited","ExitCode":0,"FinishedAt":%q}`, now.Add(-2*time.Hour).Format(time.RFC3339Nano)),
+		"ccc": fmt.Sprintf(`{"Status":"dead","ExitCode":137,"FinishedAt":%q}`, now.Add(-5*time.Minute).Format(time.RFC3339Nano)),
+		// 运行中的容器保留上一次退出的时间
+		"ddd": fmt.Sprintf(`{"Status":"running","Running":true,"FinishedAt":%q}`, now.Add(-time.Minute).Format(time.RFC3339Nano)),
+		// 创建后从未运行过
+		"eee": `{"Status":"exited","FinishedAt":"0001-01-01T00:00:00Z"}`,
+	}
+	fakeDocker(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			var list []string
+			for id := range states {
+				list = append(list, fmt.Sprintf(`{"Id":%q,"Names":["/c-%s"],"Image":"app:1","State":"exited"}`, id, id))
+			}
+			io.WriteString(w, "["+strings.Join(list, ",")+"]")
+		case strings.HasSuffix(r.URL.Path, "/json"):
+			id := containerIDOf(r)
+			fmt.Fprintf(w, `{"Id":%q,"Name":"/c-%s","State":%s}`, id, id, states[id])
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	families := gather(t, testExporter("state", "inspect"))
+	exited := families["container_recently_exited"]
+	want := map[string]string{"aaa": "1", "ccc": "137"}
+	if exited == nil || len(exited.GetMetric()) != len(want) {
+		t.Fatalf("container_recently_exited = %v, want %d containers", exited, len(want))
+	}
+	for id, exitCode := range want {
+		m := findMetric(exited, map[string]string{"id": id, "exit_code": exitCode})
+		if m == nil {
+			t.Errorf("container_recently_exited{id=%q,exit_code=%q} missing", id, exitCode)
+			continue
+		}
+		if age := now.Sub(time.Unix(int64(metricValue(m)), 0)); age < time.Minute || age > 6*time.Minute {
+			t.Errorf("container_recently_exited{id=%q} = %v, not the exit time", id, metricValue(m))
+		}
+	}
+}
+
+func TestInspectRemovedAfterList(t *testing.T) {
+	fakeDocker(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			io.WriteString(w, `[{"Id":"aaa","Names":["/web"],"Image":"nginx:1.21","State":"running","Status":"Up 1 hour"},
+				{"Id":"bbb","Names":["/job"],"Image":"busybox","State":"exited","Status":"Exited (0) 1 second ago"}]`)
+		case strings.HasSuffix(r.URL.Path, "/json"):
+			// job在list之后、inspect之前被 --rm 删除
+			if containerIDOf(r) == "bbb" {
+				http.Error(w, `{"message":"No such container: bbb"}`, http.StatusNotFound)
+				return
+			}
+			io.WriteString(w, `{"Id":"aaa","Name":"/web","State":{"Status":"running","Running":true,"StartedAt":"2024-01-31T00:00:00Z"}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	families := gather(t, testExporter("state", "inspect"))
+
+	for _, name := range []string{"web", "job"} {
+		if findMetric(families["container_run_state"], map[string]string{"name": name}) == nil {
+			t.Errorf("container_run_state{name=%q} missing", name)
+		}
+	}
+	startTime := families["container_start_time_seconds"]
+	if startTime == nil || len(startTime.GetMetric()) != 1 || findMetric(startTime, map[string]string{"name": "web"}) == nil {
+		t.Errorf("container_start_time_seconds = %v, want only web", startTime)
+	}
+	// 容器被删除是正常情况，不算采集失败
+	if m := findMetric(families["container_exporter_collector_errors_total"], map[string]string{"collector": "inspect"}); m == nil || metricValue(m) != 0 {
+		t.Errorf("container_exporter_collector_errors_total{collector=inspect} = %v, want 0", m)
+	}
+	if m := findMetric(families["container_exporter_scrape_success"], nil); m == nil || metricValue(m) != 1 {
+		t.Errorf("container_exporter_scrape_success = %v, want 1", m)
+	}
+}
+
+func TestUnlimited(t *testing.T) {
+	hostConfigs := map[string]string{
+		"mem":       `{"Memory":536870912}`,
+		"nanocpus":  `{"NanoCpus":500000000}`,
+		"quota":     `{"CpuQuota":50000,"CpuPeriod":100000}`,
+		"unlimited": `{"Memory":0,"NanoCpus":0,"CpuQuota":0}`,
+		// 只设置了CPU份额不算限制
+		"shares": `{"CpuShares":512}`,
+		"none":   `null`,
+	}
+	fakeDocker(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			var list []string
+			for id := range hostConfigs {
+				list = append(list, fmt.Sprintf(`{"Id":%q,"Names":["/%s"],"Image":"app:1","State":"running"}`, id, id))
+			}
+			io.WriteString(w, "["+strings.Join(list, ",")+"]")
+		case strings.HasSuffix(r.URL.Path, "/json"):
+			id := containerIDOf(r)
+			fmt.Fprintf(w, `{"Id":%q,"Name":"/%s","State":{"Status":"running","Running":true},"HostConfig":%s}`, id, id, hostConfigs[id])
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	unlimited := gather(t, testExporter("inspect"))["container_unlimited"]
+	want := map[string]float64{"mem": 0, "nanocpus": 0, "quota": 0, "unlimited": 1, "shares": 1, "none": 1}
+	for name, value := range want {
+		if m := findMetric(unlimited, map[string]string{"name": name}); m == nil || metricValue(m) != value {
+			t.Errorf("container_unlimited{name=%q} = %v, want %v", name, m, value)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ContainerStateJSON /metrics.json 中的一个容器
+type ContainerStateJSON struct {
+	Name   string            `json:"name"`
+	ID     string            `json:"id"`
+	Image  string            `json:"image"`
+	State  string            `json:"state"`
+	Value  float64           `json:"value"`
+	Labels map[string]string `json:"labels"`
+}
+
+// JSONHandler 处理 /metrics.json，从registry采集container_run_state并以JSON数组返回，
+// 与 /metrics 使用相同的数据，labels中是指标上的所有label，包括 --const-label
+func JSONHandler(g prometheus.Gatherer, names LabelNames) http.Handler {
+	labelNames := names.Rename("name", "id", "image", "state")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		families, err := g.Gather()
+		if err != nil && len(families) == 0 {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		containers := []ContainerStateJSON{}
+		for _, family := range families {
+			if family.GetName() != "container_run_state" {
+				continue
+			}
+			for _, metric := range family.Metric {
+				labels := make(map[string]string, len(metric.Label))
+				for _, label := range metric.Label {
+					labels[label.GetName()] = label.GetValue()
+				}
+				// --metric-type untyped 时值在Untyped中
+				value := metric.GetGauge().GetValue()
+				if metric.Untyped != nil {
+					value = metric.GetUntyped().GetValue()
+				}
+				containers = append(containers, ContainerStateJSON{
+					Name:   labels[labelNames[0]],
+					ID:     labels[labelNames[1]],
+					Image:  labels[labelNames[2]],
+					State:  labels[labelNames[3]],
+					Value:  value,
+					Labels: labels,
+				})
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(containers)
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// labelMetrics --label-to-metric 指定的容器label到指标名的映射
+var labelMetrics = map[string]string{}
+
+func init() {
+	flag.Var(labelMetricsFlag(labelMetrics), "label-to-metric", "Container label whose numeric value is reported as a gauge by the labels collector, as label=metric, e.g. monitor.expected_replicas=container_expected_replicas, can be repeated.")
+}
+
+// labelMetricsFlag 可以重复指定的 label=metric 参数
+type labelMetricsFlag map[string]string
+
+func (f labelMetricsFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for label, metric := range f {
+		pairs = append(pairs, label+"="+metric)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f labelMetricsFlag) Set(s string) error {
+	// 指标名中不能有=，label中可以有
+	i := strings.LastIndex(s, "=")
+	if i <= 0 {
+		return fmt.Errorf("expected label=metric, got %q", s)
+	}
+	label, metric := s[:i], s[i+1:]
+	if !model.IsValidMetricName(model.LabelValue(metric)) {
+		return fmt.Errorf("invalid metric name %q", metric)
+	}
+	if _, ok := f[label]; ok {
+		return fmt.Errorf("label %q is given more than once", label)
+	}
+	for other, name := range f {
+		if name == metric {
+			return fmt.Errorf("label %q and %q are both reported as %q", other, label, metric)
+		}
+	}
+	f[label] = metric
+	return nil
+}
+
+// labelMetric 一个 --label-to-metric 对应的指标
+type labelMetric struct {
+	label string
+	desc  *prometheus.Desc
+}
+
+// NewLabelMetrics 按label排序，每次采集的输出顺序一致
+func NewLabelMetrics(labels func(...string) []string) []labelMetric {
+	keys := make([]string, 0, len(labelMetrics))
+	for label := range labelMetrics {
+		keys = append(keys, label)
+	}
+	sort.Strings(keys)
+	metrics := make([]labelMetric, 0, len(keys))
+	for _, label := range keys {
+		metrics = append(metrics, labelMetric{
+			label: label,
+			desc: prometheus.NewDesc(
+				labelMetrics[label],
+				fmt.Sprintf("value of the container label %s", label),
+				labels("name", "id"),
+				constLabels),
+		})
+	}
+	return metrics
+}
+
+// collectLabelMetrics 输出带有对应label且值是数字的容器，其他值忽略
+func (e *Exporter) collectLabelMetrics(ch chan<- prometheus.Metric, info types.Container, name string) {
+	for _, m := range e.labelMetrics {
+		value, ok := info.Labels[m.label]
+		if !ok {
+			continue
+		}
+		number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			debugLog("ignore label %s=%q of container %s, not a number", m.label, value, name)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(m.desc, prometheus.GaugeValue, number, name, info.ID)
+	}
+}
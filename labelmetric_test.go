@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+// useLabelMetrics 在测试期间加入 --label-to-metric，结束后删除
+func useLabelMetrics(tb testing.TB, pairs ...string) {
+	tb.Helper()
+	for _, pair := range pairs {
+		if err := labelMetricsFlag(labelMetrics).Set(pair); err != nil {
+			tb.Fatalf("set label-to-metric %s: %v", pair, err)
+		}
+	}
+	tb.Cleanup(func() {
+		for label := range labelMetrics {
+			delete(labelMetrics, label)
+		}
+	})
+}
+
+func TestCollectLabelMetrics(t *testing.T) {
+	useLabelMetrics(t, "monitor.replicas=container_expected_replicas")
+	useContainers(t,
+		types.Container{ID: "a1", Names: []string{"/int"}, State: "running", Labels: map[string]string{"monitor.replicas": "3"}},
+		types.Container{ID: "b2", Names: []string{"/float"}, State: "running", Labels: map[string]string{"monitor.replicas": " 2.5 "}},
+		types.Container{ID: "c3", Names: []string{"/word"}, State: "running", Labels: map[string]string{"monitor.replicas": "three"}},
+		types.Container{ID: "d4", Names: []string{"/empty"}, State: "running", Labels: map[string]string{"monitor.replicas": ""}},
+		types.Container{ID: "e5", Names: []string{"/missing"}, State: "running"},
+	)
+	family := gather(t, testExporter("labels"))["container_expected_replicas"]
+	if family == nil {
+		t.Fatal("container_expected_replicas not exported")
+	}
+	if got := len(family.GetMetric()); got != 2 {
+		t.Errorf("got %d series, want 2", got)
+	}
+	for name, want := range map[string]float64{"int": 3, "float": 2.5} {
+		m := findMetric(family, map[string]string{"name": name})
+		if m == nil {
+			t.Errorf("missing series for %s", name)
+			continue
+		}
+		if got := metricValue(m); got != want {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+	for _, name := range []string{"word", "empty", "missing"} {
+		if findMetric(family, map[string]string{"name": name}) != nil {
+			t.Errorf("unexpected series for %s", name)
+		}
+	}
+}
+
+func TestLabelMetricsFlag(t *testing.T) {
+	for _, tc := range []struct {
+		values []string
+		ok     bool
+	}{
+		{[]string{"a=metric_a", "b=metric_b"}, true},
+		{[]string{"a.b=c=metric_a"}, true},
+		{[]string{"metric_a"}, false},
+		{[]string{"=metric_a"}, false},
+		{[]string{"a=metric-a"}, false},
+		{[]string{"a=metric_a", "a=metric_b"}, false},
+		{[]string{"a=metric_a", "b=metric_a"}, false},
+	} {
+		f := labelMetricsFlag{}
+		var err error
+		for _, value := range tc.values {
+			if err = f.Set(value); err != nil {
+				break
+			}
+		}
+		if (err == nil) != tc.ok {
+			t.Errorf("Set(%q) error = %v, want ok %v", tc.values, err, tc.ok)
+		}
+	}
+}
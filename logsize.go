@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/docker/docker/api/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectLogSize 输出json-file日志驱动的日志文件大小，包括max-file轮转出的旧文件(<id>-json.log.1等)，
+// 其他日志驱动不在本机保存可以直接统计的文件，不输出
+func (e *Exporter) collectLogSize(ch chan<- prometheus.Metric, container types.ContainerJSON, name string) {
+	if container.HostConfig == nil || container.HostConfig.LogConfig.Type != "json-file" || container.LogPath == "" {
+		return
+	}
+	logPath := filepath.Join(*logPathPrefix, container.LogPath)
+	files, err := filepath.Glob(logPath + ".*")
+	if err != nil {
+		// 只有pattern不合法才会出错
+		files = nil
+	}
+	size, found := int64(0), false
+	for _, file := range append([]string{logPath}, files...) {
+		stat, err := os.Stat(file)
+		if os.IsNotExist(err) {
+			// 还没有输出过日志，或者轮转时文件被删除
+			continue
+		}
+		if err != nil {
+			errorLog.Printf("log-size:"+container.ID, "stat log file of container %s err, %v", name, err)
+			e.collectorErrors.WithLabelValues("log-size").Inc()
+			return
+		}
+		size += stat.Size()
+		found = true
+	}
+	if !found {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(e.logSize, prometheus.GaugeValue, float64(size), name, container.ID)
+}
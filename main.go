@@ -2,15 +2,25 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
+	"github.com/docker/cli/cli/connhelper"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
+	"hash/fnv"
 	"log"
+	"math"
 	"net/http"
+	"net/http/pprof"
+	"net/url"
 	"os"
 	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -20,7 +30,101 @@ import (
 
 // 1. 定义一个结构体，用于存放描述信息
 type Exporter struct {
-	queryDockerStatus *prometheus.Desc
+	queryDockerStatus     *prometheus.Desc
+	podInfo               *prometheus.Desc
+	mountsCount           *prometheus.Desc
+	networksCount         *prometheus.Desc
+	portsCount            *prometheus.Desc
+	portOk                *prometheus.Desc
+	labelsPresent         *prometheus.Desc
+	bandwidthLimit        *prometheus.Desc
+	scrapeSuccess         *prometheus.Desc
+	ready                 *prometheus.Desc
+	startTime             *prometheus.Desc
+	imageCreatedTime      *prometheus.Desc
+	imageStaleness        *prometheus.Desc
+	imagePlatformInfo     *prometheus.Desc
+	unlimited             *prometheus.Desc
+	restartBackoff        *prometheus.Desc
+	restartingDuration    *prometheus.Desc
+	restartedByPolicy     *prometheus.Desc
+	restartMaxRetries     *prometheus.Desc
+	recentlyExited        *prometheus.Desc
+	restartsSinceStart    *prometheus.Desc
+	runAsRoot             *prometheus.Desc
+	containerInfo         *prometheus.Desc
+	defaultSeccomp        *prometheus.Desc
+	securityInfo          *prometheus.Desc
+	capabilitiesInfo      *prometheus.Desc
+	dangerousCap          *prometheus.Desc
+	sharesHostPid         *prometheus.Desc
+	sharesHostIpc         *prometheus.Desc
+	sharesHostNetwork     *prometheus.Desc
+	ulimitSoft            *prometheus.Desc
+	ulimitHard            *prometheus.Desc
+	graphDriverInfo       *prometheus.Desc
+	runtimeInfo           *prometheus.Desc
+	cpusetInfo            *prometheus.Desc
+	cpusetCount           *prometheus.Desc
+	cpuShares             *prometheus.Desc
+	blkioWeight           *prometheus.Desc
+	lastSeen              *prometheus.Desc
+	stateCount            *prometheus.Desc
+	nameCollision         *prometheus.Desc
+	orphan                *prometheus.Desc
+	stateChanged          *prometheus.Desc
+	imageUsageCount       *prometheus.Desc
+	swarmTaskState        *prometheus.Desc
+	snapshotAge           *prometheus.Desc
+	apiVersionInfo        *prometheus.Desc
+	defaultRuntimeInfo    *prometheus.Desc
+	swarmActive           *prometheus.Desc
+	onDefaultBridge       *prometheus.Desc
+	networkAliasInfo      *prometheus.Desc
+	dnsInfo               *prometheus.Desc
+	extraHostInfo         *prometheus.Desc
+	gpuCount              *prometheus.Desc
+	gpuInfo               *prometheus.Desc
+	healthStatus          *prometheus.Desc
+	logSize               *prometheus.Desc
+	swapUsage             *prometheus.Desc
+	swapLimit             *prometheus.Desc
+	cpuThrottledPeriods   *prometheus.Desc
+	cpuThrottledTime      *prometheus.Desc
+	cpuUsagePercent       *prometheus.Desc
+	healthcheckConfigInfo *prometheus.Desc
+
+	// 每次采集的耗时分布，失败的采集同样记录
+	scrapeDuration  prometheus.Histogram
+	scrapeErrors    *prometheus.CounterVec
+	scrapesTotal    prometheus.Counter
+	collectorErrors *prometheus.CounterVec
+
+	// 以下是跨采集共享的状态。HTTP抓取、推送到pushgateway可能并发调用Collect，
+	// 后台的事件流和轮询同时在更新ContainerStore，Exporter本身不加锁，
+	// 每个共享状态自带锁或使用原子操作，新增的状态也需要自己保证并发安全
+	snapshot *Snapshot
+	// 容器所在主机的名称，用于host label
+	host string
+	// 上一次采集重名的容器名，变化时才打印日志
+	lastCollisions atomic.Value
+	readiness      *Readiness
+	restarts       *RestartDeltas
+	stateHistory   *StateHistory
+	images         *ImageCache
+	daemonInfo     *DaemonInfo
+	config         *Config
+	collectors     map[string]bool
+	requiredLabels []string
+	labelMetrics   []labelMetric
+	dangerousCaps  []string
+	exposedEnv     []string
+	// --skip-states 中的状态，这些容器只计入汇总指标
+	skipStates map[string]bool
+	// docker拒绝inspect后置为1
+	inspectDenied int32
+	// 采集失败日志的限流key，/metrics 和 /metrics/extended 的Exporter各自限流，互不吞掉对方的错误和恢复日志
+	logKey string
 }
 
 // 2. 定义一个Collector接口，用于存放两个必备函数，Describe和Collect
@@ -33,84 +137,1373 @@ type Collector interface {
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	// 将描述信息放入队列
 	ch <- e.queryDockerStatus
+	ch <- e.podInfo
+	ch <- e.mountsCount
+	ch <- e.networksCount
+	ch <- e.portsCount
+	ch <- e.portOk
+	ch <- e.labelsPresent
+	for _, m := range e.labelMetrics {
+		ch <- m.desc
+	}
+	ch <- e.bandwidthLimit
+	ch <- e.scrapeSuccess
+	ch <- e.ready
+	ch <- e.startTime
+	ch <- e.imageCreatedTime
+	ch <- e.imagePlatformInfo
+	ch <- e.imageStaleness
+	ch <- e.unlimited
+	ch <- e.restartBackoff
+	ch <- e.restartingDuration
+	ch <- e.restartedByPolicy
+	ch <- e.restartMaxRetries
+	ch <- e.recentlyExited
+	ch <- e.restartsSinceStart
+	ch <- e.runAsRoot
+	ch <- e.containerInfo
+	ch <- e.defaultSeccomp
+	ch <- e.securityInfo
+	ch <- e.capabilitiesInfo
+	ch <- e.dangerousCap
+	ch <- e.sharesHostPid
+	ch <- e.sharesHostIpc
+	ch <- e.sharesHostNetwork
+	ch <- e.ulimitSoft
+	ch <- e.ulimitHard
+	ch <- e.graphDriverInfo
+	ch <- e.runtimeInfo
+	ch <- e.cpusetInfo
+	ch <- e.cpusetCount
+	ch <- e.cpuShares
+	ch <- e.blkioWeight
+	ch <- e.lastSeen
+	ch <- e.stateCount
+	ch <- e.nameCollision
+	ch <- e.orphan
+	ch <- e.stateChanged
+	ch <- e.imageUsageCount
+	ch <- e.swarmTaskState
+	ch <- e.snapshotAge
+	ch <- e.apiVersionInfo
+	ch <- e.defaultRuntimeInfo
+	ch <- e.swarmActive
+	ch <- e.onDefaultBridge
+	ch <- e.networkAliasInfo
+	ch <- e.dnsInfo
+	ch <- e.extraHostInfo
+	ch <- e.gpuCount
+	ch <- e.gpuInfo
+	ch <- e.healthStatus
+	ch <- e.logSize
+	ch <- e.swapUsage
+	ch <- e.swapLimit
+	ch <- e.cpuThrottledPeriods
+	ch <- e.cpuThrottledTime
+	ch <- e.cpuUsagePercent
+	ch <- e.healthcheckConfigInfo
+	e.scrapeDuration.Describe(ch)
+	e.scrapesTotal.Describe(ch)
+	e.scrapeErrors.Describe(ch)
+	e.collectorErrors.Describe(ch)
 }
 
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	for _, info := range GetContainerList() {
-		log.Println(info)
-		ch <- prometheus.MustNewConstMetric(
-			e.queryDockerStatus,
-			prometheus.GaugeValue,
-			0,
-			strings.TrimPrefix(info.Names[0], "/"), // 指标的标签值与NewDesc中的第三个参数一样对应
-			info.ID,
-			info.Image,
-			info.Status,
-			info.State,
-		)
+	start := time.Now()
+	e.scrapesTotal.Inc()
+	success := 1.0
+	if err := e.collect(ch); err != nil {
+		e.daemonInfo.Invalidate()
+		errorType := ClassifyDockerError(err)
+		errorLog.Printf(e.logKey, "%s, %v", errorTypeHints[errorType], err)
+		e.scrapeErrors.WithLabelValues(errorType).Inc()
+		success = 0
+	} else {
+		e.readiness.MarkReady()
+		errorLog.Recovered(e.logKey, "collect containers recovered")
+	}
+	e.scrapeDuration.Observe(time.Since(start).Seconds())
+	e.scrapeDuration.Collect(ch)
+	e.scrapesTotal.Collect(ch)
+	e.scrapeErrors.Collect(ch)
+	e.collectorErrors.Collect(ch)
+	ch <- prometheus.MustNewConstMetric(e.scrapeSuccess, prometheus.GaugeValue, success)
+	ch <- prometheus.MustNewConstMetric(e.ready, prometheus.GaugeValue, boolValue(e.readiness.Ready()))
+}
+
+// collect 采集所有容器的指标，GetContainerList同时返回部分结果和错误时，仍然输出已拿到的容器
+func (e *Exporter) collect(ch chan<- prometheus.Metric) error {
+	containerList, err := GetContainerList()
+	if err != nil {
+		// 容器列表是所有采集器的基础，计入state
+		e.collectorErrors.WithLabelValues("state").Inc()
+	}
+	// docker短暂不可用时继续输出上一次成功的结果，避免容器指标全部消失引起告警风暴
+	stale := false
+	if e.snapshot != nil {
+		list, age, ok := e.snapshot.Update(containerList, err, time.Now())
+		if ok {
+			// 输出的是旧列表时daemon不可用，不再对每个容器inspect和查询stats，避免每个请求都等到超时
+			stale = err != nil && len(containerList) == 0
+			containerList = list
+			ch <- prometheus.MustNewConstMetric(e.snapshotAge, prometheus.GaugeValue, age.Seconds())
+		}
+	}
+	e.images.Begin()
+	stateScrape := e.stateHistory.Begin()
+	now := time.Now()
+	seenAt := now
+	if BackgroundStore != nil {
+		seenAt = BackgroundStore.SeenAt()
+	}
+	// 按状态汇总容器数量，没有容器时也输出0，方便dashboard正常展示
+	stateCount := make(map[string]int, len(ContainerStatusMap))
+	for state := range ContainerStatusMap {
+		stateCount[state] = 0
+	}
+	// 按镜像和版本统计容器数量
+	imageUsage := map[[2]string]int{}
+	selected := e.selectContainers(containerList, now)
+	var orphans map[string][]string
+	// 镜像列表失败与容器列表无关，记录状态之后再计入返回的错误
+	var imageListErr error
+	if *onlyOrphans {
+		var existing map[string]bool
+		existing, imageListErr = ExistingImages()
+		if imageListErr != nil {
+			// 无法判断镜像是否存在，本次只按compose项目判断
+			errorLog.Printf("image-list", "list images err, %v", imageListErr)
+		}
+		orphans = make(map[string][]string)
+		kept := selected[:0]
+		for _, s := range selected {
+			if reasons := OrphanReasons(s.info, existing); len(reasons) > 0 {
+				orphans[s.info.ID] = reasons
+				kept = append(kept, s)
+			}
+		}
+		selected = kept
+	}
+	if e.collectors["state"] {
+		collisions := NameCollisions(selected)
+		if last, _ := e.lastCollisions.Load().(string); strings.Join(collisions, ",") != last {
+			if len(collisions) > 0 {
+				log.Printf("warning: container names appear more than once on %s: %s", e.host, strings.Join(collisions, ", "))
+			}
+			e.lastCollisions.Store(strings.Join(collisions, ","))
+		}
+		for _, name := range collisions {
+			ch <- prometheus.MustNewConstMetric(e.nameCollision, prometheus.GaugeValue, 1, name, e.host)
+		}
+	}
+	var stats map[string]*types.StatsJSON
+	if e.collectors["stats"] && !stale {
+		var failed int
+		stats, failed = FetchStats(selected)
+		e.collectorErrors.WithLabelValues("stats").Add(float64(failed))
+	}
+	names := make([]string, len(selected))
+	for i, s := range selected {
+		names[i] = s.target.Name
+	}
+	restarts := e.restarts.Begin(names)
+	// 每个指标的label值在MustNewConstMetric中会被复制，可以在容器之间复用同一个slice，减少每次采集的分配
+	nameID := make([]string, 2)
+	var stateValues []string
+	for _, s := range selected {
+		info, target, name := s.info, s.target, s.target.Name
+		nameID[0], nameID[1] = name, info.ID
+		// 直接判断参数，避免不开启时也把容器装箱成interface产生分配
+		if *logDebug {
+			log.Println(info)
+		}
+		var container types.ContainerJSON
+		inspected := false
+		// 按健康状态过滤需要先inspect
+		if *filterHealth != "" {
+			if !stale {
+				container, inspected = e.inspect(info, name)
+			}
+			if !inspected || HealthStatus(container) != *filterHealth {
+				continue
+			}
+		}
+		state := GetContainerState(info.State)
+		stateCount[state]++
+		// 跳过的状态也要记录，容器之后变为其他状态时才能发现变化
+		from, changedAt, changed := stateScrape.Observe(info.ID, state)
+		repository, version := e.images.Version(info.ImageID, target.Image)
+		imageUsage[[2]string{repository, version}]++
+		if e.skipStates[state] {
+			continue
+		}
+		// 跳过的容器不inspect，大量已退出的旧容器不会拖慢采集
+		if *filterHealth == "" && !stale {
+			container, inspected = e.inspect(info, name)
+		}
+		if e.collectors["state"] {
+			if changed {
+				ch <- prometheus.MustNewConstMetric(e.stateChanged, prometheus.GaugeValue, float64(changedAt.Unix()), name, info.ID, from, state)
+			}
+			stateValue := GetContainerStateValue(info.State)
+			if overrides, ok := info.Labels[*stateValuesLabel]; ok {
+				if values, err := ParseStateValues(overrides); err != nil {
+					errorLog.Printf("state-values:"+info.ID, "ignore label %s of container %s, %v", *stateValuesLabel, name, err)
+				} else if value, ok := values[state]; ok {
+					stateValue = value
+				}
+			}
+			stateValues = e.appendStateLabelValues(stateValues[:0], name, info.ID, target.Image, target.Status, info.State, "local")
+			ch <- prometheus.MustNewConstMetric(
+				e.queryDockerStatus,
+				MetricTypes[*metricType],
+				stateValue,
+				// 指标的标签值与NewDesc中的第三个参数一样对应
+				stateValues...,
+			)
+			if pod, namespace, ok := PodOf(info.Labels); ok {
+				ch <- prometheus.MustNewConstMetric(e.podInfo, prometheus.GaugeValue, 1, name, info.ID, pod, namespace)
+			}
+			for _, reason := range orphans[info.ID] {
+				ch <- prometheus.MustNewConstMetric(e.orphan, prometheus.GaugeValue, 1, name, info.ID, reason)
+			}
+		}
+
+		if e.collectors["last-seen"] && !seenAt.IsZero() {
+			ch <- prometheus.MustNewConstMetric(e.lastSeen, prometheus.GaugeValue, float64(seenAt.Unix()), nameID...)
+		}
+
+		// 挂载、网络、端口数量，直接取自容器列表，无需inspect
+		if e.collectors["counts"] {
+			networks := 0
+			if info.NetworkSettings != nil {
+				networks = len(info.NetworkSettings.Networks)
+			}
+			ch <- prometheus.MustNewConstMetric(e.mountsCount, prometheus.GaugeValue, float64(len(info.Mounts)), nameID...)
+			ch <- prometheus.MustNewConstMetric(e.networksCount, prometheus.GaugeValue, float64(networks), nameID...)
+			ch <- prometheus.MustNewConstMetric(e.portsCount, prometheus.GaugeValue, float64(len(info.Ports)), nameID...)
+		}
+
+		// 只对配置了期望端口的容器检查端口是否都已发布
+		if expected, ok := e.config.ExpectedPorts[name]; ok && e.collectors["ports"] {
+			ch <- prometheus.MustNewConstMetric(e.portOk, prometheus.GaugeValue, PortsPublished(info.Ports, expected), nameID...)
+		}
+
+		if len(e.requiredLabels) > 0 && e.collectors["labels"] {
+			ch <- prometheus.MustNewConstMetric(e.labelsPresent, prometheus.GaugeValue, LabelsPresent(info.Labels, e.requiredLabels), nameID...)
+		}
+		if e.collectors["labels"] {
+			e.collectLabelMetrics(ch, info, name)
+		}
+
+		if e.collectors["bandwidth"] {
+			if value, ok := info.Labels[*bandwidthLabel]; ok {
+				if limit, err := ParseBandwidth(value); err != nil {
+					errorLog.Printf("bandwidth:"+info.ID, "parse label %s of container %s err, %v", *bandwidthLabel, name, err)
+				} else {
+					ch <- prometheus.MustNewConstMetric(e.bandwidthLimit, prometheus.GaugeValue, limit, nameID...)
+				}
+			}
+		}
+
+		if inspected {
+			e.collectInspect(ch, info, container, name, e.images, restarts)
+		}
+		if e.collectors["image-platform"] {
+			e.collectImagePlatform(ch, info, name, e.images)
+		}
+		if containerStats, ok := stats[info.ID]; ok {
+			e.collectStats(ch, containerStats, container, inspected, name)
+		}
+	}
+	// 列表不完整时保留上一次的记录，避免恢复后把所有容器都当作新容器。
+	// swarm等其他请求失败与容器列表无关，不影响记录
+	if err == nil {
+		stateScrape.Commit()
+		restarts.Prune()
+		err = imageListErr
+	}
+
+	if e.collectors["swarm-tasks"] {
+		if swarmErr := e.collectSwarmTasks(ch, containerList, stateCount); swarmErr != nil {
+			e.collectorErrors.WithLabelValues("swarm-tasks").Inc()
+			if err == nil {
+				err = swarmErr
+			}
+		}
+	}
+	if e.collectors["state"] {
+		for state, count := range stateCount {
+			ch <- prometheus.MustNewConstMetric(e.stateCount, prometheus.GaugeValue, float64(count), state)
+		}
+	}
+
+	if e.collectors["images"] {
+		for image, count := range imageUsage {
+			ch <- prometheus.MustNewConstMetric(e.imageUsageCount, prometheus.GaugeValue, float64(count), image[0], image[1])
+		}
+	}
+
+	if e.collectors["daemon"] {
+		if daemonErr := e.collectDaemon(ch); daemonErr != nil {
+			e.collectorErrors.WithLabelValues("daemon").Inc()
+			if err == nil {
+				err = daemonErr
+			}
+		}
+	}
+	return err
+}
+
+// appendStateLabelValues 把container_run_state的label值追加到values后返回，开启 --swarm-tasks-as-containers 时带上source
+func (e *Exporter) appendStateLabelValues(values []string, name, id, image, status, state, source string) []string {
+	values = append(values, name, id, image, status, state)
+	if *swarmTasksAsContainers {
+		values = append(values, source)
+	}
+	return values
+}
+
+// LabelsPresent 容器设置了所有必需的label时返回1，否则返回0
+func LabelsPresent(labels map[string]string, required []string) float64 {
+	for _, key := range required {
+		if _, ok := labels[key]; !ok {
+			return 0
+		}
+	}
+	return 1
+}
+
+// newSnapshot 开启 --serve-stale 时创建Snapshot
+func newSnapshot() *Snapshot {
+	if !*serveStale {
+		return nil
 	}
+	return NewSnapshot(*maxStaleness)
+}
+
+// selectedContainer 经过过滤和relabel后需要输出的容器
+type selectedContainer struct {
+	info   types.Container
+	target RelabelTarget
+}
+
+// selectContainers 按分片、创建时间、opt-in label和relabel规则过滤容器
+func (e *Exporter) selectContainers(containerList []types.Container, now time.Time) []selectedContainer {
+	selected := make([]selectedContainer, 0, len(containerList))
+	for _, info := range containerList {
+		if !InShard(info.ID, *shard, *totalShards) || !InAgeRange(info.Created, now, *minAge, *maxAge) || !OptedIn(info.Labels, *optInLabel) {
+			continue
+		}
+		target := RelabelTarget{Name: ContainerName(info), Image: info.Image, Status: info.Status}
+		if !Relabel(e.config.RelabelConfigs, info, &target) {
+			continue
+		}
+		selected = append(selected, selectedContainer{info: info, target: target})
+	}
+	return selected
+}
+
+// ContainerName 返回指标中使用的容器名，容器带有 --name-label 时使用label的值，
+// 每次部署名称都会变化的容器(如app_1、app_2)可以借此保持序列稳定，relabel规则在此基础上继续改写
+func ContainerName(info types.Container) string {
+	if *nameLabel != "" {
+		if name := info.Labels[*nameLabel]; name != "" {
+			return name
+		}
+	}
+	return strings.TrimPrefix(info.Names[0], "/")
+}
+
+// NameCollisions 返回出现不止一次的容器名，通常是relabel规则把不同的容器改成了同一个名称
+func NameCollisions(selected []selectedContainer) []string {
+	count := map[string]int{}
+	for _, s := range selected {
+		count[s.target.Name]++
+	}
+	var collisions []string
+	for name, n := range count {
+		if n > 1 {
+			collisions = append(collisions, name)
+		}
+	}
+	sort.Strings(collisions)
+	return collisions
+}
+
+// InShard 按容器id的fnv哈希判断容器是否属于当前分片，同一个容器总是落在同一个分片
+func InShard(id string, shard, totalShards int) bool {
+	if totalShards <= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int(h.Sum32()%uint32(totalShards)) == shard
+}
+
+// CheckAgeRange 检查 --min-age 和 --max-age，min大于max时不会有任何容器被输出
+func CheckAgeRange(minAge, maxAge time.Duration) error {
+	if minAge < 0 || maxAge < 0 {
+		return fmt.Errorf("--min-age and --max-age must not be negative")
+	}
+	if maxAge > 0 && minAge > maxAge {
+		return fmt.Errorf("--min-age %s is greater than --max-age %s", minAge, maxAge)
+	}
+	return nil
+}
+
+// InAgeRange 判断容器的创建时间距今是否在 [minAge, maxAge] 内，0表示不限制
+func InAgeRange(created int64, now time.Time, minAge, maxAge time.Duration) bool {
+	age := now.Sub(time.Unix(created, 0))
+	if minAge > 0 && age < minAge {
+		return false
+	}
+	if maxAge > 0 && age > maxAge {
+		return false
+	}
+	return true
+}
+
+// OptedIn 判断容器是否带有 --opt-in-label 指定的label，格式为key=value或key，未设置时所有容器都输出
+func OptedIn(labels map[string]string, optIn string) bool {
+	if optIn == "" {
+		return true
+	}
+	key, want := optIn, ""
+	if i := strings.Index(optIn, "="); i >= 0 {
+		key, want = optIn[:i], optIn[i+1:]
+	}
+	value, ok := labels[key]
+	if !ok {
+		return false
+	}
+	return want == "" || value == want
+}
+
+// SplitList 解析逗号分隔的参数，忽略空白项
+func SplitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// PortsPublished 期望的端口都已发布到宿主机时返回1，否则返回0
+func PortsPublished(ports []types.Port, expected []uint16) float64 {
+	// 端口数量很少，直接遍历，避免每次采集分配map
+	for _, want := range expected {
+		found := false
+		for _, port := range ports {
+			if port.PublicPort != 0 && port.PublicPort == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0
+		}
+	}
+	return 1
 }
 
 // 5. 定义一个实例化函数，用于生成prometheus数据
-func NewExporter() *Exporter {
-	return &Exporter{
+// 目前一个exporter只连接一个docker daemon。以后同时采集多个主机时，所有主机必须共用这里创建的Desc，
+// 主机通过host之类的可变label区分，不能为每个主机各自NewExporter后注册到同一个registry，
+// 否则同名指标的Desc重复，Register会失败
+func NewExporter(config *Config, collectors map[string]bool) *Exporter {
+	// label名称和container_run_state的help信息可以在配置文件中覆盖
+	labels := config.LabelNames.Rename
+	// 已在启动时检查过 --expose-env
+	exposedEnv, _ := ExposableEnv(SplitList(*exposeEnv))
+	infoLabels := []string{"name", "id", "image_id", "log_driver", "registry", "hostname", "domainname"}
+	for _, env := range exposedEnv {
+		infoLabels = append(infoLabels, EnvLabelName(env))
+	}
+	graphDriverLabels := []string{"name", "id", "driver"}
+	if *graphDriverPaths {
+		graphDriverLabels = append(graphDriverLabels, "upper_dir", "merged_dir")
+	}
+	healthLabels := []string{"name", "id", "health"}
+	if *healthOutputLength > 0 {
+		healthLabels = append(healthLabels, "last_output")
+	}
+	stateLabels := []string{"name", "id", "image", "status", "state"}
+	if *swarmTasksAsContainers {
+		stateLabels = append(stateLabels, "source")
+	}
+	skipped := map[string]bool{}
+	for _, state := range SplitList(*skipStates) {
+		skipped[state] = true
+	}
+	stateHelp := "query container status "
+	if config.StateHelp != "" {
+		stateHelp = config.StateHelp
+	}
+	e := &Exporter{
+		config:         config,
+		collectors:     collectors,
+		requiredLabels: SplitList(*requireLabels),
+		labelMetrics:   NewLabelMetrics(labels),
+		snapshot:       newSnapshot(),
+		readiness:      NewReadiness(*startupGrace),
+		restarts:       NewRestartDeltas(),
+		stateHistory:   NewStateHistory(*stateChangeWindow),
+		images:         NewImageCache(*imageCacheSize),
+		host:           DockerHostName(),
+		daemonInfo:     &DaemonInfo{},
+		dangerousCaps:  NormalizeCapabilities(SplitList(*dangerousCaps)),
+		exposedEnv:     exposedEnv,
+		skipStates:     skipped,
+		scrapeDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name: "container_exporter_scrape_duration_histogram",
+			Help: "duration of scrapes against the docker daemon in seconds",
+			// 1ms到10s
+			Buckets:     prometheus.ExponentialBuckets(0.001, math.Sqrt(10), 9),
+			ConstLabels: constLabels,
+		}),
+		scrapesTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "container_exporter_scrapes_total",
+			Help:        "number of scrapes of the exporter, including failed ones",
+			ConstLabels: constLabels,
+		}),
+		scrapeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "container_exporter_scrape_errors_total",
+			Help:        "number of failed scrapes of the docker daemon by error type",
+			ConstLabels: constLabels,
+		}, []string{"error_type"}),
+		collectorErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "container_exporter_collector_errors_total",
+			Help:        "number of failed docker requests by the collector needing them, a failed container inspect is counted for every enabled collector based on inspect",
+			ConstLabels: constLabels,
+		}, []string{"collector"}),
 		queryDockerStatus: prometheus.NewDesc(
-			"container_run_state",                                //指标名称
-			"query container status ",                              // 指标help信息
-			[]string{"name", "id", "image", "status", "state"}, 		// 指标的label名称
-			nil),
+			"container_run_state",  //指标名称
+			stateHelp,              // 指标help信息
+			labels(stateLabels...), // 指标的label名称
+			constLabels),
+		podInfo: prometheus.NewDesc(
+			"container_pod_info",
+			"pod and namespace of the container created by kubelet, from the io.kubernetes.pod.* labels, value is always 1",
+			labels("name", "id", "pod", "namespace"),
+			constLabels),
+		mountsCount: prometheus.NewDesc(
+			"container_mounts_count",
+			"number of mounts of the container",
+			labels("name", "id"),
+			constLabels),
+		networksCount: prometheus.NewDesc(
+			"container_networks_count",
+			"number of networks the container is attached to",
+			labels("name", "id"),
+			constLabels),
+		portsCount: prometheus.NewDesc(
+			"container_ports_count",
+			"number of ports of the container",
+			labels("name", "id"),
+			constLabels),
+		portOk: prometheus.NewDesc(
+			"container_port_ok",
+			"whether all expected ports of the container are published (1 for yes, 0 for no)",
+			labels("name", "id"),
+			constLabels),
+		scrapeSuccess: prometheus.NewDesc(
+			"container_exporter_scrape_success",
+			"whether the last scrape of the docker daemon succeeded (1 for yes, 0 for no)",
+			nil,
+			constLabels),
+		ready: prometheus.NewDesc(
+			"container_exporter_ready",
+			"whether the exporter has listed the containers successfully at least once since startup (1 for yes, 0 for no)",
+			nil,
+			constLabels),
+		startTime: prometheus.NewDesc(
+			"container_start_time_seconds",
+			"start time of the container since unix epoch in seconds",
+			labels("name", "id"),
+			constLabels),
+		imagePlatformInfo: prometheus.NewDesc(
+			"container_image_platform_info",
+			"platform of the container image from image inspect, value is always 1",
+			labels("name", "id", "image", "os", "architecture", "variant"),
+			constLabels),
+		imageCreatedTime: prometheus.NewDesc(
+			"container_image_created_time_seconds",
+			"creation time of the container image since unix epoch in seconds",
+			labels("name", "id", "image"),
+			constLabels),
+		imageStaleness: prometheus.NewDesc(
+			"container_image_staleness_seconds",
+			"seconds between the image creation and the container start",
+			labels("name", "id", "image"),
+			constLabels),
+		unlimited: prometheus.NewDesc(
+			"container_unlimited",
+			"whether the container runs without memory and CPU limits (1 for yes, 0 for no)",
+			labels("name", "id"),
+			constLabels),
+		restartBackoff: prometheus.NewDesc(
+			"container_restart_backoff_seconds",
+			"best-effort estimate of the delay before docker restarts the restarting container, derived from RestartCount",
+			labels("name", "id"),
+			constLabels),
+		restartingDuration: prometheus.NewDesc(
+			"container_restarting_duration_seconds",
+			"seconds since the restarting container last exited",
+			labels("name", "id"),
+			constLabels),
+		restartedByPolicy: prometheus.NewDesc(
+			"container_restarted_by_policy",
+			"best-effort guess whether the last start of the container was done by its restart policy rather than by an operator (1 for yes, 0 for no), derived from RestartCount",
+			labels("name", "id"),
+			constLabels),
+		restartMaxRetries: prometheus.NewDesc(
+			"container_restart_max_retries",
+			"MaximumRetryCount of the restart policy of the container, only meaningful for the on-failure policy where 0 means unlimited",
+			labels("name", "id", "policy"),
+			constLabels),
+		restartsSinceStart: prometheus.NewDesc(
+			"container_restarts_since_start_total",
+			"number of restarts of the containers with this name observed since the exporter started, continued across recreation",
+			labels("name"),
+			constLabels),
+		recentlyExited: prometheus.NewDesc(
+			"container_recently_exited",
+			fmt.Sprintf("exit time since unix epoch in seconds of the containers that exited within the last %s, by exit code", *recentlyExitedWindow),
+			labels("name", "id", "exit_code"),
+			constLabels),
+		runAsRoot: prometheus.NewDesc(
+			"container_run_as_root",
+			"whether the container runs as root (1 for yes, 0 for no)",
+			labels("name", "id"),
+			constLabels),
+		containerInfo: prometheus.NewDesc(
+			"container_info",
+			"details of the container from inspect, registry is the registry host of the image with docker.io for Docker Hub, hostname is empty when it is the default short container id, value is always 1",
+			labels(infoLabels...),
+			constLabels),
+		defaultSeccomp: prometheus.NewDesc(
+			"container_default_seccomp",
+			"whether the container runs with the default seccomp profile (1 for yes, 0 for unconfined or custom)",
+			labels("name", "id"),
+			constLabels),
+		securityInfo: prometheus.NewDesc(
+			"container_security_info",
+			"seccomp mode (default, unconfined or custom) and apparmor profile of the container, value is always 1",
+			labels("name", "id", "seccomp", "apparmor"),
+			constLabels),
+		capabilitiesInfo: prometheus.NewDesc(
+			"container_capabilities_info",
+			"linux capabilities added to and dropped from the container, value is always 1",
+			labels("name", "id", "cap_add", "cap_drop"),
+			constLabels),
+		dangerousCap: prometheus.NewDesc(
+			"container_has_dangerous_cap",
+			"whether the container is privileged or has any capability given by --dangerous-caps (1 for yes, 0 for no)",
+			labels("name", "id"),
+			constLabels),
+		sharesHostPid: prometheus.NewDesc(
+			"container_shares_host_pid",
+			"whether the container shares the pid namespace of the host (1 for yes, 0 for no)",
+			labels("name", "id"),
+			constLabels),
+		sharesHostIpc: prometheus.NewDesc(
+			"container_shares_host_ipc",
+			"whether the container shares the ipc namespace of the host (1 for yes, 0 for no)",
+			labels("name", "id"),
+			constLabels),
+		sharesHostNetwork: prometheus.NewDesc(
+			"container_shares_host_network",
+			"whether the container shares the network namespace of the host (1 for yes, 0 for no)",
+			labels("name", "id"),
+			constLabels),
+		cpusetInfo: prometheus.NewDesc(
+			"container_cpuset_info",
+			"CPUs the container is pinned to with --cpuset-cpus, only for pinned containers, value is always 1",
+			labels("name", "id", "cpuset"),
+			constLabels),
+		cpusetCount: prometheus.NewDesc(
+			"container_cpuset_count",
+			"number of CPUs the container is pinned to with --cpuset-cpus, 0 for containers that are not pinned",
+			labels("name", "id"),
+			constLabels),
+		cpuShares: prometheus.NewDesc(
+			"container_cpu_shares",
+			"relative CPU weight of the container set with --cpu-shares, the default 1024 when not set",
+			labels("name", "id"),
+			constLabels),
+		blkioWeight: prometheus.NewDesc(
+			"container_blkio_weight",
+			"relative block IO weight of the container set with --blkio-weight, the default 500 when not set",
+			labels("name", "id"),
+			constLabels),
+		runtimeInfo: prometheus.NewDesc(
+			"container_runtime_info",
+			"OCI runtime of the container from inspect, e.g. runc or runsc, value is always 1",
+			labels("name", "id", "runtime"),
+			constLabels),
+		graphDriverInfo: prometheus.NewDesc(
+			"container_graphdriver_info",
+			"storage driver of the container from inspect, value is always 1",
+			labels(graphDriverLabels...),
+			constLabels),
+		// ulimit的名称放在ulimit label中，name已经是容器名
+		ulimitSoft: prometheus.NewDesc(
+			"container_ulimit_soft",
+			"soft limit of the ulimit configured for the container, e.g. nofile, only ulimits set with --ulimit",
+			labels("name", "id", "ulimit"),
+			constLabels),
+		ulimitHard: prometheus.NewDesc(
+			"container_ulimit_hard",
+			"hard limit of the ulimit configured for the container, e.g. nofile, only ulimits set with --ulimit",
+			labels("name", "id", "ulimit"),
+			constLabels),
+		// 容器消失后这条时间序列随之消失，Prometheus会在下一次抓取时打上staleness标记，
+		// 之后可以用 last_over_time(container_last_seen_timestamp_seconds[1h]) 查到容器最后出现的时间
+		lastSeen: prometheus.NewDesc(
+			"container_last_seen_timestamp_seconds",
+			"last time the exporter observed the container since unix epoch in seconds",
+			labels("name", "id"),
+			constLabels),
+		stateCount: prometheus.NewDesc(
+			"container_state_count",
+			"number of containers by state",
+			labels("state"),
+			constLabels),
+		stateChanged: prometheus.NewDesc(
+			"container_state_changed",
+			"containers whose state changed within --state-change-window, value is the unix time the change was observed",
+			labels("name", "id", "from_state", "to_state"),
+			constLabels),
+		nameCollision: prometheus.NewDesc(
+			"container_name_collision",
+			"container names that appear more than once in a scrape, value is always 1",
+			labels("name", "host"),
+			constLabels),
+		orphan: prometheus.NewDesc(
+			"container_orphan",
+			"leftover containers reported with --only-orphans, reason is image_removed or no_compose_project, value is always 1",
+			labels("name", "id", "reason"),
+			constLabels),
+		imageUsageCount: prometheus.NewDesc(
+			"container_image_usage_count",
+			"number of containers using the image, digests are stripped",
+			labels("image", "version"),
+			constLabels),
+		swarmTaskState: prometheus.NewDesc(
+			"container_swarm_task_state",
+			"whether the swarm task reached its desired state (1 for yes, 0 for no)",
+			labels("service", "slot", "task_id", "node", "node_state", "desired_state", "state"),
+			constLabels),
+		snapshotAge: prometheus.NewDesc(
+			"container_exporter_snapshot_age_seconds",
+			"age of the container list served with --serve-stale, 0 when the last scrape succeeded",
+			nil,
+			constLabels),
+		onDefaultBridge: prometheus.NewDesc(
+			"container_on_default_bridge",
+			"whether the container is attached to the default bridge network (1 for yes, 0 for no)",
+			labels("name", "id"),
+			constLabels),
+		networkAliasInfo: prometheus.NewDesc(
+			"container_network_alias_info",
+			"network aliases of the container, value is always 1",
+			labels("name", "id", "network", "alias"),
+			constLabels),
+		dnsInfo: prometheus.NewDesc(
+			"container_dns_info",
+			"DNS servers and search domains set with --dns and --dns-search, one series with either server or domain for each, value is always 1",
+			labels("name", "id", "server", "domain"),
+			constLabels),
+		extraHostInfo: prometheus.NewDesc(
+			"container_extra_host_info",
+			"entries added to /etc/hosts of the container with --add-host, ip is host-gateway when resolved by the daemon, value is always 1",
+			labels("name", "id", "hostname", "ip"),
+			constLabels),
+		gpuCount: prometheus.NewDesc(
+			"container_gpu_count",
+			"number of GPUs requested by the container, -1 for all GPUs of the host",
+			labels("name", "id"),
+			constLabels),
+		gpuInfo: prometheus.NewDesc(
+			"container_gpu_info",
+			"GPU device request of the container, value is always 1",
+			labels("name", "id", "driver", "device_ids", "capabilities"),
+			constLabels),
+		apiVersionInfo: prometheus.NewDesc(
+			"docker_api_version_info",
+			"docker API version used by the exporter and version of the docker daemon, value is always 1",
+			labels("api_version", "server_version"),
+			constLabels),
+		defaultRuntimeInfo: prometheus.NewDesc(
+			"docker_default_runtime_info",
+			"default OCI runtime of the docker daemon, e.g. runc, value is always 1",
+			labels("runtime"),
+			constLabels),
+		swarmActive: prometheus.NewDesc(
+			"docker_swarm_active",
+			"whether the docker daemon is a node of a swarm, as manager or worker (1 for yes, 0 for no)",
+			nil,
+			constLabels),
+		healthStatus: prometheus.NewDesc(
+			"container_health_status",
+			"health status of the container with a healthcheck, 0 unhealthy, 1 healthy, 2 starting",
+			labels(healthLabels...),
+			constLabels),
+		healthcheckConfigInfo: prometheus.NewDesc(
+			"container_healthcheck_config_info",
+			"effective healthcheck parameters of the container, docker defaults are filled in, value is always 1",
+			labels("name", "id", "interval", "timeout", "retries", "start_period"),
+			constLabels),
+		swapUsage: prometheus.NewDesc(
+			"container_memory_swap_usage_bytes",
+			"swap used by the container, only when the daemon reports swap accounting",
+			labels("name", "id"),
+			constLabels),
+		swapLimit: prometheus.NewDesc(
+			"container_memory_swap_limit_bytes",
+			"swap limit of the container, only when the daemon reports swap accounting and the limit is set",
+			labels("name", "id"),
+			constLabels),
+		cpuUsagePercent: prometheus.NewDesc(
+			"container_cpu_usage_percent",
+			"CPU usage of the container since the previous stats sample, computed like docker stats, 100 for each fully used CPU",
+			labels("name", "id"),
+			constLabels),
+		cpuThrottledPeriods: prometheus.NewDesc(
+			"container_cpu_throttled_periods_total",
+			"number of CFS periods in which the container was throttled by its CPU quota",
+			labels("name", "id"),
+			constLabels),
+		cpuThrottledTime: prometheus.NewDesc(
+			"container_cpu_throttled_time_total",
+			"total time in seconds the container was throttled by its CPU quota",
+			labels("name", "id"),
+			constLabels),
+		bandwidthLimit: prometheus.NewDesc(
+			"container_network_bandwidth_limit_bytes",
+			"network rate limit of the container in bytes per second, from the label given by --bandwidth-label",
+			labels("name", "id"),
+			constLabels),
+		logSize: prometheus.NewDesc(
+			"container_log_size_bytes",
+			"total size of the json-file log files of the container including the rotated ones",
+			labels("name", "id"),
+			constLabels),
+		labelsPresent: prometheus.NewDesc(
+			"container_required_labels_present",
+			"whether the container carries all labels given by --require-labels (1 for yes, 0 for no)",
+			labels("name", "id"),
+			constLabels),
+	}
+	// 启用的采集器没有出错时也输出0
+	for name := range collectors {
+		// 事件流断开不经过Exporter，事件采集器没有这个指标
+		if name != "events" {
+			e.collectorErrors.WithLabelValues(name)
+		}
 	}
+	e.logKey = fmt.Sprintf("collect:%p", e)
+	return e
 }
 
-func GetContainerList() (containerList []types.Container) {
-	//client, err := client.NewClientWithOpts(client.WithVersion("1.38"), client.WithHost("tcp://10.100.3.206:2375"))
-	client, err := client.NewClientWithOpts(client.WithVersion("1.38"))
+// ContainerStatusMap 容器状态与指标值的对应关系，1表示正常运行
+var ContainerStatusMap = map[string]float64{
+	"UNKNOW":     0,
+	"running":    1,
+	"created":    2,
+	"restarting": 3,
+	"removing":   4,
+	"paused":     5,
+	"exited":     6,
+	"dead":       7,
+}
 
-	if err != nil {
-		log.Printf("connect docker server err, %#v", err)
-		return
+// ContainerStateAliases 其他写法的容器状态，例如Windows daemon会返回 Up 2 hours 这种与Status相同的写法
+var ContainerStateAliases = map[string]string{
+	"up":      "running",
+	"stopped": "exited",
+}
+
+// GetContainerState 规范化容器状态，忽略大小写和首尾空白，未知状态返回UNKNOW
+func GetContainerState(state string) string {
+	state = strings.ToLower(strings.TrimSpace(state))
+	// 只取第一个单词，例如 exited (0) 3 hours ago
+	if i := strings.IndexAny(state, " ("); i >= 0 {
+		state = state[:i]
+	}
+	if alias, ok := ContainerStateAliases[state]; ok {
+		state = alias
+	}
+	if _, ok := ContainerStatusMap[state]; ok {
+		return state
 	}
-	containerList, err = client.ContainerList(context.Background(), types.ContainerListOptions{All: true})
+	return "UNKNOW"
+}
+
+// GetContainerStateValue 返回容器状态对应的指标值，未知状态返回UNKNOW的值
+func GetContainerStateValue(state string) float64 {
+	return ContainerStatusMap[GetContainerState(state)]
+}
+
+// ParseStateValues 解析 --state-values-label 指定的label，格式为 state:value，多个用逗号分隔，例如 exited:1,created:1
+func ParseStateValues(s string) (map[string]float64, error) {
+	values := map[string]float64{}
+	for _, pair := range SplitList(s) {
+		i := strings.Index(pair, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("expected state:value, got %q", pair)
+		}
+		state := strings.TrimSpace(pair[:i])
+		if _, ok := ContainerStatusMap[state]; !ok {
+			return nil, fmt.Errorf("unknown state %q", state)
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(pair[i+1:]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of state %s, %v", state, err)
+		}
+		values[state] = value
+	}
+	return values, nil
+}
+
+// DockerClient 全局复用的docker客户端，避免每次采集都新建连接
+var DockerClient *client.Client
+
+// InitDockerConnect 初始化docker客户端
+// --docker-host 支持 unix://、tcp:// 以及 ssh://user@host，
+// ssh方式通过本机的ssh命令建立隧道，认证依赖ssh-agent或~/.ssh下的密钥，远端需要docker 18.09以上
+func InitDockerConnect() (err error) {
+	// 默认与daemon协商API版本，--docker-api-version 可以固定版本
+	opts := []client.Opt{client.WithAPIVersionNegotiation()}
+	if *userAgent != "" {
+		// API版本低于1.25时docker client不会发送自定义的User-Agent
+		opts = append(opts, client.WithHTTPHeaders(map[string]string{"User-Agent": *userAgent}))
+	}
+	if *dockerAPIVersion != "" {
+		opts = append(opts, client.WithVersion(*dockerAPIVersion))
+	}
+	if *dockerHost != "" {
+		helper, err := connhelper.GetConnectionHelper(*dockerHost)
+		if err != nil {
+			return err
+		}
+		if helper != nil {
+			opts = append(opts,
+				client.WithHTTPClient(&http.Client{Transport: &http.Transport{DialContext: helper.Dialer}}),
+				client.WithHost(helper.Host),
+				client.WithDialContext(helper.Dialer))
+		} else {
+			opts = append(opts, client.WithHost(*dockerHost))
+		}
+	}
+	if *dockerTLSCert != "" || *dockerTLSKey != "" || *dockerTLSCA != "" {
+		if *dockerTLSCert == "" || *dockerTLSKey == "" {
+			return fmt.Errorf("--docker-tls-cert and --docker-tls-key must be set together")
+		}
+		opts = append(opts, client.WithTLSClientConfig(*dockerTLSCA, *dockerTLSCert, *dockerTLSKey))
+	}
+	DockerClient, err = client.NewClientWithOpts(opts...)
 	if err != nil {
-		log.Printf("connect docker server err, %#v", err)
 		return
 	}
+	// ssh隧道在第一次请求时才建立，启动时先ping一次，尽早暴露认证等问题
+	if strings.HasPrefix(*dockerHost, "ssh://") {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if _, err = DockerClient.Ping(ctx); err != nil {
+			DockerClient.Close()
+			return fmt.Errorf("ssh tunnel to %s failed, check that ssh is installed and the key is loaded into ssh-agent: %w", *dockerHost, err)
+		}
+	}
 	return
 }
 
+// DockerHostName 返回容器所在的主机，--docker-host 为tcp或ssh地址时取其中的主机名，否则为本机的hostname
+func DockerHostName() string {
+	if u, err := url.Parse(*dockerHost); err == nil && (u.Scheme == "tcp" || u.Scheme == "ssh") && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// Version exporter的版本，构建时通过 -ldflags "-X main.Version=..." 设置
+var Version = "dev"
+
+// BackgroundStore 开启 --event-driven 或 --poll-interval 时在后台维护的容器列表
+var BackgroundStore *ContainerStore
+
+// containerListOptions 获取容器列表的参数，包含已停止的容器
+var containerListOptions = types.ContainerListOptions{All: true}
+
+// GetContainerList 获取容器列表。docker的ContainerList在某个容器的字段类型无法解析时
+// (例如兼容docker API的其他runtime返回了不同的类型)，仍然返回其余解析成功的容器和错误；
+// 请求失败或响应被截断时只返回错误
+func GetContainerList() ([]types.Container, error) {
+	if *sourceFile != "" {
+		return LoadContainerFile(*sourceFile)
+	}
+	if BackgroundStore != nil {
+		return BackgroundStore.List()
+	}
+	if *backend == "cri" {
+		return ListCRIContainers()
+	}
+	return DockerClient.ContainerList(context.Background(), containerListOptions)
+}
+
+// MetricsHandler 返回 /metrics 的handler，客户端带 Accept-Encoding: gzip 时promhttp压缩返回，
+// 容器多时可以显著减少流量。原样转发请求，不能改写或丢弃请求头，否则gzip协商会失效
+func MetricsHandler(gatherer prometheus.Gatherer) http.Handler {
+	h := promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Println("start...")
+		h.ServeHTTP(w, r)
+	})
+}
+
 var (
-	address = flag.String("listen-address", ":9417", "The address to listen on for HTTP requests.")
+	address       = flag.String("listen-address", ":9417", "The address to listen on for HTTP requests.")
+	webAddress    = flag.String("web.listen-address", ":9417", "Alias of --listen-address, following the convention of the official exporters.")
+	tlsCertFile   = flag.String("tls-cert-file", "", "Path to the TLS certificate file, serves /metrics over HTTPS together with --tls-key-file.")
+	tlsKeyFile    = flag.String("tls-key-file", "", "Path to the TLS private key file.")
+	tlsMinVersion = flag.String("tls-min-version", "1.2", "Minimum TLS version of the HTTPS server: 1.0, 1.1, 1.2 or 1.3.")
+	check         = flag.Bool("check", false, "Check the connection to the daemon and the permissions needed by the enabled collectors, print the result and exit, non-zero on failure.")
+	configFile    = flag.String("config.file", "", "Path to the optional YAML configuration file.")
+	backend       = flag.String("backend", "docker", "Container runtime to list containers from: docker, or cri for containerd and CRI-O on Kubernetes nodes.")
+	criEndpoint   = flag.String("cri-endpoint", "unix:///run/containerd/containerd.sock", "CRI v1 endpoint of --backend cri, e.g. unix:///var/run/crio/crio.sock for CRI-O.")
+	dockerHost    = flag.String("docker-host", "", "Docker daemon host, e.g. unix:///var/run/docker.sock, tcp://host:2375, ssh://user@host or npipe:////./pipe/docker_engine on Windows.")
+	// Docker-in-Docker的官方镜像默认开启TLS(DOCKER_TLS_CERTDIR=/certs)，监听2376端口，
+	// 客户端证书生成在 /certs/client 下，例如
+	// --docker-host=tcp://localhost:2376 --docker-tls-ca=/certs/client/ca.pem --docker-tls-cert=/certs/client/cert.pem --docker-tls-key=/certs/client/key.pem
+	dockerTLSCA   = flag.String("docker-tls-ca", "", "CA certificate verifying a tcp:// --docker-host over TLS, e.g. /certs/client/ca.pem of Docker-in-Docker.")
+	dockerTLSCert = flag.String("docker-tls-cert", "", "Client certificate for a tcp:// --docker-host over TLS, e.g. /certs/client/cert.pem of Docker-in-Docker.")
+	dockerTLSKey  = flag.String("docker-tls-key", "", "Client key for a tcp:// --docker-host over TLS, e.g. /certs/client/key.pem of Docker-in-Docker.")
+	daemonLabel   = flag.String("daemon-label", "", "Value of a daemon label added to every metric, to tell apart exporters of nested Docker-in-Docker and host daemons.")
+	// daemon日志和authz插件可以通过User-Agent识别exporter的请求
+	userAgent        = flag.String("user-agent", "container_state_exporter/"+Version, "User-Agent of the requests to the docker daemon.")
+	dockerAPIVersion = flag.String("docker-api-version", "", "Docker API version to use, negotiated with the daemon when empty.")
+	pollInterval     = flag.Duration("poll-interval", 0, "Refresh the container list in the background at this interval instead of on every scrape, 0 disables.")
+	pollJitter       = flag.Float64("poll-jitter", 0.1, "Random jitter added to --poll-interval, as a fraction of the interval.")
+	// 在容器中运行时日志文件的路径是宿主机上的路径，需要挂载 /var/lib/docker/containers 并设置前缀
+	logPathPrefix  = flag.String("log-path-prefix", "", "Prefix of the log file paths read by the log-size collector, e.g. /host when /var/lib/docker/containers of the host is mounted at /host/var/lib/docker/containers.")
+	logDebug       = flag.Bool("log-debug", false, "Also log expected conditions, e.g. containers removed between listing and inspecting them.")
+	eventDriven    = flag.Bool("event-driven", false, "Maintain the container list from the docker event stream instead of listing containers on every scrape.")
+	restartsWindow = flag.Duration("restarts-window", 15*time.Minute, "Window of container_restarts_recent, counted by the events collector.")
+	dangerousCaps  = flag.String("dangerous-caps", "SYS_ADMIN,NET_ADMIN,SYS_PTRACE,SYS_MODULE", "Comma-separated capabilities reported by container_has_dangerous_cap.")
+	shard          = flag.Int("shard", 0, "Index of the shard of containers reported by this exporter, from 0 to --total-shards - 1.")
+	totalShards    = flag.Int("total-shards", 1, "Number of exporter replicas sharing the containers of the host.")
+	sourceFile     = flag.String("source-file", "", "Serve metrics from a JSON file of containers instead of the docker daemon, re-read on every scrape.")
+	// 重复输出旧数据期间Prometheus不会给这些序列打staleness标记，容器真正消失要等到超过 --max-staleness 才能看出来
+	serveStale   = flag.Bool("serve-stale", false, "Serve the last successful container list when the docker daemon is unavailable.")
+	maxStaleness = flag.Duration("max-staleness", 5*time.Minute, "Maximum age of the container list served with --serve-stale.")
+	// 环境变量的值会原样出现在指标中，任何能访问 /metrics 的人都能看到，只应该加入版本号之类的非敏感变量
+	exposeEnv    = flag.String("expose-env", "", "Comma-separated environment variables exposed as env_<name> labels of container_info by the inspect collector, names containing PASSWORD, SECRET or TOKEN are never exposed.")
+	minAge       = flag.Duration("min-age", 0, "Only report containers created at least this long ago, 0 disables.")
+	maxAge       = flag.Duration("max-age", 0, "Only report containers created at most this long ago, 0 disables.")
+	nameLabel    = flag.String("name-label", "prometheus.name", "Container label overriding the name label of the metrics of the container, the container name when absent, empty disables.")
+	optInLabel   = flag.String("opt-in-label", "", "Only report containers carrying this label, as key=value or key, e.g. monitor=true.")
+	skipStates   = flag.String("skip-states", "", "Comma-separated container states, e.g. exited,created, whose containers are only counted in container_state_count and container_image_usage_count.")
+	filterHealth = flag.String("filter-health", "", "Only report containers with this health status: healthy, unhealthy, starting or none, requires the health collector at /metrics.")
+	// 带时间戳的序列在容器消失后不会被Prometheus打上staleness标记，会在查询中继续出现5分钟(lookback delta)，
+	// 而且时间戳早于该序列已有样本时会被拒绝，只应在remote write回填等需要显式时间戳的场景开启
+	metricTimestamps = flag.Bool("metric-timestamps", false, "Attach the collection time to every metric instead of letting Prometheus assign the scrape time, disables staleness handling of disappeared containers.")
+	versionRegex     = flag.String("version-regex", "", "Regex extracting the version label of container_image_usage_count from the image with its first capture group, the tag or the short digest of untagged images when empty or not matching.")
+	pushGateway      = flag.String("push-gateway", "", "URL of a Pushgateway to push the metrics of /metrics to periodically, for hosts that can not be scraped, can not be used with --extended-collectors.")
+	pushInterval     = flag.Duration("push-interval", time.Minute, "Interval of pushing to --push-gateway.")
+	pushJob          = flag.String("push-job", "container_state_exporter", "Job label of the metrics pushed to --push-gateway.")
+	pushInstance     = flag.String("push-instance", "", "Instance label of the metrics pushed to --push-gateway, the hostname when empty.")
+	disableHTTP      = flag.Bool("disable-http", false, "Do not serve HTTP, only push the metrics to --push-gateway.")
+	metricsAllowlist = flag.String("metrics-allowlist", "", "Comma-separated metric names to expose at /metrics, /metrics/extended and to --push-gateway, all metrics when empty.")
+	enableJSON       = flag.Bool("enable-json", false, "Serve container_run_state of every container as a JSON array at /metrics.json.")
+	// pprof会暴露命令行参数和内存内容，只应在排查问题时临时开启
+	enablePprof          = flag.Bool("enable-pprof", false, "Serve the pprof profiles of the exporter at /debug/pprof/.")
+	recentlyExitedWindow = flag.Duration("recently-exited-window", 15*time.Minute, "Window of container_recently_exited reported by the inspect collector.")
+	stateChangeWindow    = flag.Duration("state-change-window", 5*time.Minute, "Time container_state_changed is reported after a state change, so that every scrape and consumer within the window sees it.")
+	startupGrace         = flag.Duration("startup-grace", 5*time.Second, "Time after startup during which /healthz reports starting instead of unavailable until the first successful container list.")
+	// 每个容器的目录都不同，容器重建后产生新的序列
+	graphDriverPaths = flag.Bool("graphdriver-paths", false, "Add the upper_dir and merged_dir labels of overlay2 to container_graphdriver_info.")
+	// 输出经常包含时间、耗时等每次都不同的内容，每次检查都可能产生新的序列，所以默认不开启
+	healthOutputLength = flag.Int("health-output-length", 0, fmt.Sprintf("Add the last_output label with the output of the last healthcheck, truncated to this many characters, to container_health_status, at most %d, 0 disables.", maxHealthOutputLength))
+	// 每次采集多一次image list请求
+	onlyOrphans = flag.Bool("only-orphans", false, "Only report leftover containers whose image was removed or that are not part of a compose project, with container_orphan reported by the state collector, lists the images on every scrape.")
+	// 本机上的task容器已经按本地容器输出，不会重复计入
+	swarmTasksAsContainers = flag.Bool("swarm-tasks-as-containers", false, "Also report the swarm tasks collected by the swarm-tasks collector in container_run_state and container_state_count, with a source label of local or swarm.")
+	// 缓存的镜像在容器不再使用后按最近使用的顺序淘汰，镜像id变化即是新的缓存项
+	imageCacheSize = flag.Int("image-cache-size", 256, "Number of image inspect results kept across scrapes, 0 inspects the images again on every scrape.")
+	metricType     = flag.String("metric-type", "gauge", "Type of container_run_state: gauge, or untyped for legacy consumers.")
+	// 容器的所有者可以声明自己的状态语义，例如预期会退出的容器在exited时输出running的值，不触发告警
+	stateValuesLabel = flag.String("state-values-label", "monitor.state-values", "Container label overriding the value of container_run_state of the container per state, e.g. exited:1.")
+	// 只读取label，限速本身由tc等外部工具按照同一个label配置
+	bandwidthLabel = flag.String("bandwidth-label", "net.bandwidth.limit", "Container label holding the network rate limit in tc units, e.g. 10mbit or 100kbps, read by the bandwidth collector.")
+	requireLabels  = flag.String("require-labels", "", "Comma-separated label keys every container is expected to carry, checked by the labels collector.")
 )
 
+// MetricTypes --metric-type 支持的取值
+var MetricTypes = map[string]prometheus.ValueType{
+	"gauge":   prometheus.GaugeValue,
+	"untyped": prometheus.UntypedValue,
+}
+
+// TLSVersions --tls-min-version 支持的取值
+var TLSVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ListenAddress 返回监听地址，--listen-address 和 --web.listen-address 设置了不同的值时以后者为准
+func ListenAddress() string {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	if set["web.listen-address"] {
+		if set["listen-address"] && *address != *webAddress {
+			log.Printf("both --listen-address=%s and --web.listen-address=%s are set, using %s", *address, *webAddress, *webAddress)
+		}
+		return *webAddress
+	}
+	return *address
+}
+
 func main() {
-	GetContainerList()
+	flag.Parse()
+	if *daemonLabel != "" {
+		if _, ok := constLabels["daemon"]; ok {
+			log.Fatalf("--daemon-label can not be used with --const-label daemon=...")
+		}
+		constLabels["daemon"] = *daemonLabel
+	}
+	config, err := LoadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("load config err, %v", err)
+	}
+	collectors, err := EnabledCollectors()
+	if err != nil {
+		log.Fatalf("parse collectors err, %v", err)
+	}
+	basicCollectors, extendedCollectors, err := SplitExtended(collectors)
+	if err != nil {
+		log.Fatalf("parse collectors err, %v", err)
+	}
+	if *sourceFile != "" && (*eventDriven || *pollInterval > 0) {
+		log.Fatalf("--source-file can not be used with --event-driven or --poll-interval")
+	}
+	if *eventDriven && *pollInterval > 0 {
+		log.Fatalf("--event-driven can not be used with --poll-interval")
+	}
+	if *pollJitter < 0 {
+		log.Fatalf("--poll-jitter must not be negative")
+	}
+	if err := CheckFilterHealth(*filterHealth, basicCollectors); err != nil {
+		log.Fatal(err)
+	}
+	if len(labelMetrics) > 0 && !collectors["labels"] {
+		log.Fatalf("--label-to-metric requires the labels collector, add --collect-labels")
+	}
+	// /metrics.json只采集/metrics的registry
+	if *enableJSON && !basicCollectors["state"] {
+		log.Fatalf("--enable-json requires the state collector at /metrics, it serves container_run_state")
+	}
+	for _, state := range SplitList(*skipStates) {
+		if _, ok := ContainerStatusMap[state]; !ok {
+			log.Fatalf("invalid state %q in --skip-states", state)
+		}
+	}
+	if _, ok := MetricTypes[*metricType]; !ok {
+		log.Fatalf("invalid --metric-type %q, must be gauge or untyped", *metricType)
+	}
+	if *versionRegex != "" {
+		if versionRegexp, err = CompileVersionRegex(*versionRegex); err != nil {
+			log.Fatalf("invalid --version-regex, %v", err)
+		}
+	}
+	if *healthOutputLength < 0 || *healthOutputLength > maxHealthOutputLength {
+		log.Fatalf("--health-output-length must be between 0 and %d", maxHealthOutputLength)
+	}
+	if *imageCacheSize < 0 {
+		log.Fatalf("--image-cache-size must not be negative")
+	}
+	if _, err := ExposableEnv(SplitList(*exposeEnv)); err != nil {
+		log.Fatalf("invalid --expose-env, %v", err)
+	}
+	if *pushGateway != "" {
+		if *pushInterval <= 0 {
+			log.Fatalf("--push-interval must be positive")
+		}
+		// Pushgateway拒绝带时间戳的指标
+		if *metricTimestamps {
+			log.Fatalf("--push-gateway can not be used with --metric-timestamps")
+		}
+		// 只推送/metrics，extended采集器的指标推送不到，两个registry都有container_exporter_scrape_*，也不能合并推送
+		if *extendedFlag != "" {
+			log.Fatalf("--push-gateway can not be used with --extended-collectors, the extended metrics would never be pushed")
+		}
+		if *pushInstance == "" {
+			*pushInstance, _ = os.Hostname()
+		}
+	} else if *disableHTTP {
+		log.Fatalf("--disable-http requires --push-gateway")
+	}
+	if *totalShards < 1 || *shard < 0 || *shard >= *totalShards {
+		log.Fatalf("invalid shard %d of %d shards", *shard, *totalShards)
+	}
+	if err := CheckAgeRange(*minAge, *maxAge); err != nil {
+		log.Fatal(err)
+	}
+	switch *backend {
+	case "docker":
+		if err := InitDockerConnect(); err != nil {
+			log.Fatalf("connect docker server err, %#v", err)
+		}
+	case "cri":
+		if *sourceFile != "" || *eventDriven || *pollInterval > 0 {
+			log.Fatalf("--backend cri can not be used with --source-file, --event-driven or --poll-interval")
+		}
+		for _, name := range dockerOnlyCollectors {
+			if collectors[name] {
+				log.Fatalf("the %s collector requires --backend docker", name)
+			}
+		}
+		if err := InitCRIConnect(); err != nil {
+			log.Fatalf("connect CRI endpoint err, %v", err)
+		}
+	default:
+		log.Fatalf("invalid --backend %q, must be docker or cri", *backend)
+	}
+	if *onlyOrphans && *backend == "cri" {
+		log.Fatalf("--only-orphans requires --backend docker")
+	}
+	if *swarmTasksAsContainers && (!collectors["state"] || !collectors["swarm-tasks"]) {
+		log.Fatalf("--swarm-tasks-as-containers requires the state and swarm-tasks collectors")
+	}
+	// 两个采集器分别在/metrics和/metrics/extended时，没有一个exporter同时开启两者，task不会输出
+	if *swarmTasksAsContainers && basicCollectors["state"] != basicCollectors["swarm-tasks"] {
+		log.Fatalf("--swarm-tasks-as-containers requires the state and swarm-tasks collectors at the same endpoint, list both or neither in --extended-collectors")
+	}
+	DisableSwarmTasksUnlessManager(collectors, basicCollectors, extendedCollectors)
+	if *check {
+		if !RunCheck(os.Stdout, config, collectors) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var subscribers []EventSubscriber
+	if *eventDriven {
+		BackgroundStore = NewContainerStore()
+		subscribers = append(subscribers, BackgroundStore)
+	}
+
 	// 6. 实例化并注册数据采集器exporter
-	workerA := NewExporter()
+	workerA := NewExporter(config, basicCollectors)
 	reg := prometheus.NewPedanticRegistry()
-	reg.MustRegister(workerA)
+	// --const-label 与指标已有的label重名时注册失败
+	register := func(reg prometheus.Registerer, cs ...prometheus.Collector) {
+		for _, c := range cs {
+			if *metricTimestamps {
+				c = TimestampedCollector{c}
+			}
+			if err := reg.Register(c); err != nil {
+				log.Fatalf("register metrics err, %v", err)
+			}
+		}
+	}
+	register(reg, workerA, NewConfigInfo(collectors), NewStartTime())
+	register(reg, NewProcessGauges()...)
+	if collectors["events"] {
+		eventCounter := NewEventCounter()
+		restartTracker := NewRestartTracker(*restartsWindow)
+		register(reg, eventCounter, restartTracker)
+		subscribers = append(subscribers, eventCounter, restartTracker)
+	}
+
+	var wg sync.WaitGroup
+	if *pollInterval > 0 {
+		BackgroundStore = NewContainerStore()
+		poller := NewPoller(BackgroundStore, *pollInterval, *pollJitter, time.Now().UnixNano())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			poller.Run(ctx)
+		}()
+	}
+	if len(subscribers) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			WatchEvents(ctx, subscribers...)
+		}()
+	}
 
 	// 7. 定义一个采集数据的采集器集合，它可以合并多个不同的采集器数据到一个结果集合中
 	gatherers := prometheus.Gatherers{
 		// prometheus.DefaultGatherer,  // 默认的数据采集器，包含go运行时的指标信息
 		reg,
 	}
+	allowlist := SplitList(*metricsAllowlist)
+	gatherer := NewAllowlistGatherer(gatherers, allowlist)
+
+	if *pushGateway != "" {
+		pusher := NewPusher(*pushGateway, *pushJob, *pushInstance, gatherer, *pushInterval)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pusher.Run(ctx)
+		}()
+	}
 
 	// 8. start http server
-	h := promhttp.HandlerFor(gatherers,
-		promhttp.HandlerOpts{
-			ErrorHandling: promhttp.ContinueOnError,
-		})
-	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
-		log.Println("start...")
-		h.ServeHTTP(w, r)
-	})
+	// 不使用http.DefaultServeMux，导入net/http/pprof时会自动在上面注册 /debug/pprof/
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", MetricsHandler(gatherer))
+	if *extendedFlag != "" {
+		extendedReg := prometheus.NewPedanticRegistry()
+		register(extendedReg, NewExporter(config, extendedCollectors))
+		mux.Handle("/metrics/extended", promhttp.HandlerFor(NewAllowlistGatherer(extendedReg, allowlist), promhttp.HandlerOpts{ErrorHandling: promhttp.ContinueOnError}))
+	}
+	mux.Handle("/healthz", workerA.readiness)
+	if *enableJSON {
+		mux.Handle("/metrics.json", JSONHandler(gatherers, config.LabelNames))
+	}
+	if *enablePprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
 
-	//flag.Parse()
-	//address = flag.String("listen-address", ":9417", "The address to listen on for HTTP requests.")
-	server := &http.Server{Addr: *address, Handler: nil}
+	server := &http.Server{Addr: ListenAddress(), Handler: mux}
+	tlsEnabled := *tlsCertFile != "" || *tlsKeyFile != ""
+	if tlsEnabled {
+		if *tlsCertFile == "" || *tlsKeyFile == "" {
+			log.Fatalf("--tls-cert-file and --tls-key-file must be set together")
+		}
+		minVersion, ok := TLSVersions[*tlsMinVersion]
+		if !ok {
+			log.Fatalf("unsupported --tls-min-version %q, must be 1.0, 1.1, 1.2 or 1.3", *tlsMinVersion)
+		}
+		server.TLSConfig = &tls.Config{MinVersion: minVersion}
+	}
 
 	go func() {
-		err := server.ListenAndServe()
+		if *disableHTTP {
+			return
+		}
+		var err error
+		if tlsEnabled {
+			err = server.ListenAndServeTLS(*tlsCertFile, *tlsKeyFile)
+		} else {
+			err = server.ListenAndServe()
+		}
 		if err != http.ErrServerClosed {
 			log.Printf("start server err, error message: %#v", err)
 			os.Exit(1)
@@ -122,9 +1515,12 @@ func main() {
 	<-quit
 	log.Println("Server shutting down...")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	if err := server.Shutdown(ctx); err != nil {
+	// 停止事件订阅
+	cancel()
+	wg.Wait()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
 		log.Println("message", fmt.Sprintf("Failed to gracefully shutdown: %v", err))
 	}
 	log.Println("Server shutdown")
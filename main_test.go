@@ -0,0 +1,487 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// benchContainers 生成n个使用少量镜像的容器
+func benchContainers(n int) []types.Container {
+	containers := make([]types.Container, n)
+	for i := range containers {
+		image := fmt.Sprintf("registry:5000/team/app-%d:1.%d.0", i%10, i%10)
+		containers[i] = types.Container{
+			ID:      fmt.Sprintf("%064x", i),
+			Names:   []string{fmt.Sprintf("/app_%d", i)},
+			Image:   image,
+			ImageID: fmt.Sprintf("sha256:%064x", i%10),
+			State:   "running",
+			Status:  "Up 2 hours",
+			Labels:  map[string]string{"com.docker.compose.project": "web"},
+		}
+	}
+	return containers
+}
+
+// drain 采集一次并丢弃结果
+func drain(c prometheus.Collector) {
+	ch := make(chan prometheus.Metric, 256)
+	done := make(chan struct{})
+	go func() {
+		for range ch {
+		}
+		close(done)
+	}()
+	c.Collect(ch)
+	close(ch)
+	<-done
+}
+
+func BenchmarkCollect(b *testing.B) {
+	useContainers(b, benchContainers(1000)...)
+	e := testExporter("state", "counts", "images")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		drain(e)
+	}
+}
+
+func TestGetContainerStateValue(t *testing.T) {
+	tests := []struct {
+		state string
+		want  float64
+	}{
+		{"running", 1},
+		{"Running", 1},
+		{"  RUNNING\n", 1},
+		{" exited ", 6},
+		{"Paused", 5},
+		{"Dead", 7},
+		{"", 0},
+		{"bogus", 0},
+	}
+	for _, tt := range tests {
+		if got := GetContainerStateValue(tt.state); got != tt.want {
+			t.Errorf("GetContainerStateValue(%q) = %v, want %v", tt.state, got, tt.want)
+		}
+	}
+}
+
+func TestCollectPartialContainerList(t *testing.T) {
+	// 第二个容器的Created类型错误，json会继续解析其余字段和容器，并返回UnmarshalTypeError
+	fakeDocker(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/containers/json") {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, `[
+			{"Id":"aaa","Names":["/web"],"Image":"nginx:1.21","State":"running","Status":"Up 1 hour","Created":1600000000},
+			{"Id":"bbb","Names":["/db"],"Image":"mysql:8","State":"exited","Status":"Exited (0)","Created":"yesterday"},
+			{"Id":"ccc","Names":["/cache"],"Image":"redis:6","State":"paused","Status":"Up 1 hour (Paused)","Created":1600000000}
+		]`)
+	}))
+
+	list, err := GetContainerList()
+	if err == nil {
+		t.Fatal("GetContainerList: want decode error, got nil")
+	}
+	if len(list) != 3 {
+		t.Fatalf("GetContainerList returned %d containers with error, want 3", len(list))
+	}
+
+	families := gather(t, testExporter("state"))
+	runState := families["container_run_state"]
+	for name, want := range map[string]float64{"web": 1, "db": 6, "cache": 5} {
+		m := findMetric(runState, map[string]string{"name": name})
+		if m == nil {
+			t.Errorf("container_run_state{name=%q} missing", name)
+			continue
+		}
+		if got := metricValue(m); got != want {
+			t.Errorf("container_run_state{name=%q} = %v, want %v", name, got, want)
+		}
+	}
+	success := families["container_exporter_scrape_success"]
+	if success == nil || metricValue(success.GetMetric()[0]) != 0 {
+		t.Errorf("container_exporter_scrape_success should be 0 after a partial list")
+	}
+}
+
+func TestMetricsHandlerGzip(t *testing.T) {
+	useContainers(t, benchContainers(3)...)
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(testExporter("state"))
+	server := httptest.NewServer(MetricsHandler(reg))
+	defer server.Close()
+
+	for _, encoding := range []string{"gzip", ""} {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		if encoding != "" {
+			req.Header.Set("Accept-Encoding", encoding)
+		}
+		// 使用自定义Transport，避免http.Client自动协商和解压gzip
+		resp, err := (&http.Transport{DisableCompression: true}).RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body io.Reader = resp.Body
+		if got := resp.Header.Get("Content-Encoding"); got != encoding {
+			t.Errorf("Accept-Encoding %q: Content-Encoding = %q, want %q", encoding, got, encoding)
+		}
+		if encoding == "gzip" {
+			if body, err = gzip.NewReader(resp.Body); err != nil {
+				t.Fatalf("response is not gzip: %v", err)
+			}
+		}
+		data, err := ioutil.ReadAll(body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), "container_run_state{") {
+			t.Errorf("Accept-Encoding %q: body missing container_run_state", encoding)
+		}
+	}
+}
+
+func TestInShard(t *testing.T) {
+	const totalShards = 4
+	counts := make([]int, totalShards)
+	for i := 0; i < 4000; i++ {
+		id := fmt.Sprintf("%064x", i*7919)
+		var owners []int
+		for shard := 0; shard < totalShards; shard++ {
+			if InShard(id, shard, totalShards) {
+				owners = append(owners, shard)
+			}
+		}
+		// 每个容器恰好属于一个分片，多次判断结果不变
+		if len(owners) != 1 {
+			t.Fatalf("container %s belongs to shards %v, want exactly one", id, owners)
+		}
+		if !InShard(id, owners[0], totalShards) {
+			t.Fatalf("container %s moved between shards", id)
+		}
+		counts[owners[0]]++
+	}
+	// 分布基本均匀，每个分片在平均值的±20%内
+	for shard, count := range counts {
+		if count < 800 || count > 1200 {
+			t.Errorf("shard %d has %d of 4000 containers, distribution %v", shard, count, counts)
+		}
+	}
+	// 只有一个分片时所有容器都属于它
+	if !InShard("abc", 0, 1) || !InShard("abc", 0, 0) {
+		t.Error("all containers belong to the only shard")
+	}
+}
+
+func TestCheckAgeRange(t *testing.T) {
+	tests := []struct {
+		minAge, maxAge time.Duration
+		ok             bool
+	}{
+		{0, 0, true},
+		{time.Minute, 0, true},
+		{0, time.Hour, true},
+		{time.Minute, time.Hour, true},
+		{time.Hour, time.Hour, true},
+		{2 * time.Hour, time.Hour, false},
+		{-time.Minute, 0, false},
+		{0, -time.Minute, false},
+	}
+	for _, tt := range tests {
+		if err := CheckAgeRange(tt.minAge, tt.maxAge); (err == nil) != tt.ok {
+			t.Errorf("CheckAgeRange(%s, %s) = %v, want ok=%v", tt.minAge, tt.maxAge, err, tt.ok)
+		}
+	}
+}
+
+// Windows daemon的list结果中State有时是Up/Stopped，Status中带有退出码和时长
+func TestGetContainerStateWindows(t *testing.T) {
+	tests := []struct {
+		state string
+		want  string
+	}{
+		{"Up", "running"},
+		{"Up 2 hours", "running"},
+		{"up (Paused)", "running"},
+		{"Stopped", "exited"},
+		{"Exited (1073741510) 2 minutes ago", "exited"},
+		{"Running", "running"},
+		{"Created", "created"},
+		{"Restarting (0) 5 seconds ago", "restarting"},
+		{"Unknown", "UNKNOW"},
+	}
+	for _, tt := range tests {
+		if got := GetContainerState(tt.state); got != tt.want {
+			t.Errorf("GetContainerState(%q) = %q, want %q", tt.state, got, tt.want)
+		}
+	}
+	if got := GetContainerStateValue("Stopped"); got != ContainerStatusMap["exited"] {
+		t.Errorf("GetContainerStateValue(Stopped) = %v, want %v", got, ContainerStatusMap["exited"])
+	}
+}
+
+func TestSkipStates(t *testing.T) {
+	setFlag(t, "skip-states", "exited,created")
+	useContainers(t,
+		types.Container{ID: "aaa", Names: []string{"/web"}, Image: "nginx:1.21", State: "running", Status: "Up 1 hour"},
+		types.Container{ID: "bbb", Names: []string{"/migrate"}, Image: "nginx:1.21", State: "exited", Status: "Exited (0) 1 hour ago"},
+		types.Container{ID: "ccc", Names: []string{"/job"}, Image: "busybox:1.35", State: "created", Status: "Created"},
+		types.Container{ID: "ddd", Names: []string{"/cache"}, Image: "redis:6", State: "paused", Status: "Up 1 hour (Paused)"})
+	families := gather(t, testExporter("state", "counts", "images"))
+
+	// 跳过的状态不输出每个容器的指标
+	for name, kept := range map[string]bool{"web": true, "migrate": false, "job": false, "cache": true} {
+		for _, metric := range []string{"container_run_state", "container_mounts_count"} {
+			if m := findMetric(families[metric], map[string]string{"name": name}); (m != nil) != kept {
+				t.Errorf("%s{name=%q} reported=%v, want %v", metric, name, m != nil, kept)
+			}
+		}
+	}
+	// 汇总的数量仍然包含跳过的容器
+	for state, want := range map[string]float64{"running": 1, "exited": 1, "created": 1, "paused": 1, "dead": 0} {
+		m := findMetric(families["container_state_count"], map[string]string{"state": state})
+		if m == nil || metricValue(m) != want {
+			t.Errorf("container_state_count{state=%q} = %v, want %v", state, m, want)
+		}
+	}
+	for image, want := range map[string]float64{"nginx": 2, "busybox": 1, "redis": 1} {
+		m := findMetric(families["container_image_usage_count"], map[string]string{"image": image})
+		if m == nil || metricValue(m) != want {
+			t.Errorf("container_image_usage_count{image=%q} = %v, want %v", image, m, want)
+		}
+	}
+}
+
+func TestPortsPublished(t *testing.T) {
+	ports := []types.Port{
+		{PrivatePort: 80, PublicPort: 8080, Type: "tcp"},
+		{PrivatePort: 443, PublicPort: 8443, Type: "tcp"},
+		// 只暴露没有发布
+		{PrivatePort: 9090, Type: "tcp"},
+	}
+	tests := []struct {
+		expected []uint16
+		want     float64
+	}{
+		{nil, 1},
+		{[]uint16{8080}, 1},
+		{[]uint16{8080, 8443}, 1},
+		{[]uint16{8080, 9000}, 0},
+		// 容器内的端口不算发布
+		{[]uint16{80}, 0},
+		{[]uint16{9090}, 0},
+	}
+	for _, tt := range tests {
+		if got := PortsPublished(ports, tt.expected); got != tt.want {
+			t.Errorf("PortsPublished(%v) = %v, want %v", tt.expected, got, tt.want)
+		}
+	}
+	if got := PortsPublished(nil, []uint16{8080}); got != 0 {
+		t.Errorf("PortsPublished without ports = %v, want 0", got)
+	}
+}
+
+func TestCollectPortOk(t *testing.T) {
+	useContainers(t,
+		types.Container{ID: "aaa", Names: []string{"/web"}, State: "running", Ports: []types.Port{{PrivatePort: 80, PublicPort: 8080}}},
+		types.Container{ID: "bbb", Names: []string{"/api"}, State: "running", Ports: []types.Port{{PrivatePort: 80, PublicPort: 8081}}},
+		types.Container{ID: "ccc", Names: []string{"/db"}, State: "running"})
+	config := &Config{ExpectedPorts: map[string][]uint16{"web": {8080}, "api": {8080}}}
+	families := gather(t, NewExporter(config, map[string]bool{"ports": true}))
+
+	portOk := families["container_port_ok"]
+	for name, want := range map[string]float64{"web": 1, "api": 0} {
+		if m := findMetric(portOk, map[string]string{"name": name}); m == nil || metricValue(m) != want {
+			t.Errorf("container_port_ok{name=%q} = %v, want %v", name, m, want)
+		}
+	}
+	// 没有配置期望端口的容器不输出
+	if findMetric(portOk, map[string]string{"name": "db"}) != nil {
+		t.Error("container_port_ok{name=db} reported without expected ports")
+	}
+}
+
+func TestRequiredLabelsPresent(t *testing.T) {
+	required := []string{"team", "env"}
+	tests := []struct {
+		labels map[string]string
+		want   float64
+	}{
+		{map[string]string{"team": "web", "env": "prod", "other": "x"}, 1},
+		// 值为空也算设置了
+		{map[string]string{"team": "", "env": "prod"}, 1},
+		{map[string]string{"team": "web"}, 0},
+		{nil, 0},
+	}
+	for _, tt := range tests {
+		if got := LabelsPresent(tt.labels, required); got != tt.want {
+			t.Errorf("LabelsPresent(%v) = %v, want %v", tt.labels, got, tt.want)
+		}
+	}
+
+	setFlag(t, "require-labels", "team, env")
+	useContainers(t,
+		types.Container{ID: "aaa", Names: []string{"/web"}, State: "running", Labels: map[string]string{"team": "web", "env": "prod"}},
+		types.Container{ID: "bbb", Names: []string{"/api"}, State: "running", Labels: map[string]string{"team": "api"}},
+		types.Container{ID: "ccc", Names: []string{"/db"}, State: "running"})
+	present := gather(t, testExporter("labels"))["container_required_labels_present"]
+	for name, want := range map[string]float64{"web": 1, "api": 0, "db": 0} {
+		if m := findMetric(present, map[string]string{"name": name}); m == nil || metricValue(m) != want {
+			t.Errorf("container_required_labels_present{name=%q} = %v, want %v", name, m, want)
+		}
+	}
+}
+
+func TestCollectEmptyContainerList(t *testing.T) {
+	fakeDocker(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/containers/json") {
+			io.WriteString(w, `[]`)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	families := gather(t, testExporter("state"))
+
+	if m := findMetric(families["container_exporter_scrape_success"], nil); m == nil || metricValue(m) != 1 {
+		t.Errorf("container_exporter_scrape_success = %v, want 1", m)
+	}
+	if families["container_run_state"] != nil {
+		t.Errorf("container_run_state reported without containers")
+	}
+	// 每个状态都输出0
+	stateCount := families["container_state_count"]
+	if stateCount == nil || len(stateCount.GetMetric()) != len(ContainerStatusMap) {
+		t.Fatalf("container_state_count = %v, want one series per state", stateCount)
+	}
+	for state := range ContainerStatusMap {
+		if m := findMetric(stateCount, map[string]string{"state": state}); m == nil || metricValue(m) != 0 {
+			t.Errorf("container_state_count{state=%q} = %v, want 0", state, m)
+		}
+	}
+}
+
+func TestSelectContainersByAge(t *testing.T) {
+	now := time.Now()
+	ages := map[string]time.Duration{"new": 30 * time.Second, "young": 10 * time.Minute, "old": 3 * time.Hour, "ancient": 30 * 24 * time.Hour}
+	var containers []types.Container
+	for name, age := range ages {
+		containers = append(containers, types.Container{ID: name, Names: []string{"/" + name}, Created: now.Add(-age).Unix()})
+	}
+	tests := []struct {
+		minAge, maxAge string
+		want           []string
+	}{
+		{"0s", "0s", []string{"ancient", "new", "old", "young"}},
+		{"1m", "0s", []string{"ancient", "old", "young"}},
+		{"0s", "1h", []string{"new", "young"}},
+		{"1m", "24h", []string{"old", "young"}},
+		{"1h", "2h", nil},
+	}
+	e := testExporter("state")
+	for _, tt := range tests {
+		setFlag(t, "min-age", tt.minAge)
+		setFlag(t, "max-age", tt.maxAge)
+		var got []string
+		for _, s := range e.selectContainers(containers, now) {
+			got = append(got, s.target.Name)
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("min-age=%s max-age=%s selected %v, want %v", tt.minAge, tt.maxAge, got, tt.want)
+		}
+	}
+	// 边界上的容器计入范围内
+	created := now.Add(-time.Hour).Unix()
+	if !InAgeRange(created, time.Unix(created, 0).Add(time.Hour), time.Hour, time.Hour) {
+		t.Error("a container exactly at min-age and max-age should be in range")
+	}
+}
+
+func TestOptedIn(t *testing.T) {
+	tests := []struct {
+		labels map[string]string
+		optIn  string
+		want   bool
+	}{
+		{nil, "", true},
+		{map[string]string{"monitor": "true"}, "monitor", true},
+		{map[string]string{"monitor": ""}, "monitor", true},
+		{map[string]string{"other": "true"}, "monitor", false},
+		{nil, "monitor", false},
+		{map[string]string{"monitor": "true"}, "monitor=true", true},
+		{map[string]string{"monitor": "false"}, "monitor=true", false},
+		{map[string]string{"other": "true"}, "monitor=true", false},
+		// key= 与 key 相同，只要带有label即可
+		{map[string]string{"monitor": "x"}, "monitor=", true},
+	}
+	for _, tt := range tests {
+		if got := OptedIn(tt.labels, tt.optIn); got != tt.want {
+			t.Errorf("OptedIn(%v, %q) = %v, want %v", tt.labels, tt.optIn, got, tt.want)
+		}
+	}
+}
+
+func TestCollectOptInLabel(t *testing.T) {
+	setFlag(t, "opt-in-label", "monitor=true")
+	useContainers(t,
+		types.Container{ID: "aaa", Names: []string{"/web"}, State: "running", Labels: map[string]string{"monitor": "true"}},
+		types.Container{ID: "bbb", Names: []string{"/api"}, State: "running", Labels: map[string]string{"monitor": "false"}},
+		types.Container{ID: "ccc", Names: []string{"/db"}, State: "running"})
+	runState := gather(t, testExporter("state"))["container_run_state"]
+	for name, want := range map[string]bool{"web": true, "api": false, "db": false} {
+		if m := findMetric(runState, map[string]string{"name": name}); (m != nil) != want {
+			t.Errorf("container_run_state{name=%q} reported=%v, want %v", name, m != nil, want)
+		}
+	}
+}
+
+func TestSkipStatesWithoutInspect(t *testing.T) {
+	setFlag(t, "skip-states", "exited")
+	var skippedInspects int32
+	fakeDocker(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			io.WriteString(w, `[{"Id":"aaa","Names":["/web"],"Image":"nginx:1.21","State":"running","Status":"Up 1 hour"},
+				{"Id":"bbb","Names":["/migrate"],"Image":"nginx:1.21","State":"exited","Status":"Exited (0) 1 hour ago"}]`)
+		case strings.HasSuffix(r.URL.Path, "/json"):
+			if containerIDOf(r) == "bbb" {
+				atomic.AddInt32(&skippedInspects, 1)
+				http.Error(w, `{"message":"server error"}`, http.StatusInternalServerError)
+				return
+			}
+			io.WriteString(w, `{"Id":"aaa","Name":"/web","State":{"Status":"running","Running":true,"StartedAt":"2024-01-31T00:00:00Z"}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	families := gather(t, testExporter("state", "inspect"))
+
+	if n := atomic.LoadInt32(&skippedInspects); n != 0 {
+		t.Errorf("skipped container inspected %d times, want 0", n)
+	}
+	if findMetric(families["container_start_time_seconds"], map[string]string{"name": "web"}) == nil {
+		t.Error("container_start_time_seconds{name=web} missing")
+	}
+	if m := findMetric(families["container_exporter_collector_errors_total"], map[string]string{"collector": "inspect"}); m != nil && metricValue(m) != 0 {
+		t.Errorf("container_exporter_collector_errors_total{collector=inspect} = %v, want 0", metricValue(m))
+	}
+	if m := findMetric(families["container_state_count"], map[string]string{"state": "exited"}); m == nil || metricValue(m) != 1 {
+		t.Errorf("container_state_count{state=exited} = %v, want 1", m)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// describe 返回采集器的所有Desc
+func describe(c prometheus.Collector) []string {
+	ch := make(chan *prometheus.Desc, 256)
+	go func() {
+		c.Describe(ch)
+		close(ch)
+	}()
+	var descs []string
+	for desc := range ch {
+		descs = append(descs, desc.String())
+	}
+	return descs
+}
+
+// 多个主机只能共用一套Desc：同样配置的Exporter的Desc完全相同，各自注册到同一个registry会失败
+func TestExporterDescsShared(t *testing.T) {
+	useContainers(t)
+	a, b := describe(testExporter("state", "inspect")), describe(testExporter("state", "inspect"))
+	if len(a) == 0 || len(a) != len(b) {
+		t.Fatalf("exporters describe %d and %d descs", len(a), len(b))
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			t.Errorf("desc %d differs between exporters: %s vs %s", i, a[i], b[i])
+		}
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(testExporter("state")); err != nil {
+		t.Fatalf("register first exporter: %v", err)
+	}
+	if err := reg.Register(testExporter("state")); err == nil {
+		t.Error("registering a second exporter with the same descs succeeded")
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectNetworkInfo 输出network-info采集器的指标
+func (e *Exporter) collectNetworkInfo(ch chan<- prometheus.Metric, container types.ContainerJSON, name string) {
+	ch <- prometheus.MustNewConstMetric(e.onDefaultBridge, prometheus.GaugeValue, OnDefaultBridge(container), name, container.ID)
+
+	// 没有设置时使用daemon或宿主机的配置，inspect中为空，不输出
+	if container.HostConfig != nil {
+		for _, server := range uniqueStrings(container.HostConfig.DNS) {
+			ch <- prometheus.MustNewConstMetric(e.dnsInfo, prometheus.GaugeValue, 1, name, container.ID, server, "")
+		}
+		for _, domain := range uniqueStrings(container.HostConfig.DNSSearch) {
+			ch <- prometheus.MustNewConstMetric(e.dnsInfo, prometheus.GaugeValue, 1, name, container.ID, "", domain)
+		}
+		for _, entry := range uniqueStrings(container.HostConfig.ExtraHosts) {
+			if hostname, ip, ok := ParseExtraHost(entry); ok {
+				ch <- prometheus.MustNewConstMetric(e.extraHostInfo, prometheus.GaugeValue, 1, name, container.ID, hostname, ip)
+			}
+		}
+	}
+
+	if container.NetworkSettings != nil {
+		emitted := 0
+		for _, networkName := range sortedKeys(container.NetworkSettings.Networks) {
+			endpoint := container.NetworkSettings.Networks[networkName]
+			if endpoint == nil {
+				continue
+			}
+			for _, alias := range uniqueStrings(endpoint.Aliases) {
+				// docker自动把短id加为别名，没有排查价值
+				if strings.HasPrefix(container.ID, alias) {
+					continue
+				}
+				if emitted >= maxAliasesPerContainer {
+					return
+				}
+				ch <- prometheus.MustNewConstMetric(e.networkAliasInfo, prometheus.GaugeValue, 1, name, container.ID, networkName, alias)
+				emitted++
+			}
+		}
+	}
+}
+
+// 每个容器最多输出的网络别名数量，避免时间序列过多
+const maxAliasesPerContainer = 16
+
+// sortedKeys 返回排序后的网络名，保证超出数量限制时每次输出的是同一批别名
+func sortedKeys(networks map[string]*network.EndpointSettings) []string {
+	keys := make([]string, 0, len(networks))
+	for key := range networks {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// uniqueStrings 去掉重复的值，docker不检查重复的 --dns 参数，重复的值会产生相同的时间序列
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	unique := values[:0:0]
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
+
+// ParseExtraHost 拆分 --add-host 的 host:ip，较新的docker也接受 host=ip。
+// ip可能是包含冒号的IPv6地址，所以按第一个分隔符拆分
+func ParseExtraHost(entry string) (hostname, ip string, ok bool) {
+	i := strings.IndexAny(entry, "=:")
+	if i <= 0 || i == len(entry)-1 {
+		return "", "", false
+	}
+	return entry[:i], entry[i+1:], true
+}
+
+// OnDefaultBridge 容器连接了默认的bridge网络时返回1，host、none网络模式返回0
+func OnDefaultBridge(container types.ContainerJSON) float64 {
+	if container.HostConfig != nil {
+		if mode := container.HostConfig.NetworkMode; mode.IsHost() || mode.IsNone() {
+			return 0
+		}
+	}
+	if container.NetworkSettings == nil {
+		return 0
+	}
+	_, ok := container.NetworkSettings.Networks["bridge"]
+	return boolValue(ok)
+}
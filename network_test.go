@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestNetworkAliasesDeduplicated(t *testing.T) {
+	fakeDocker(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			io.WriteString(w, `[{"Id":"aaa111","Names":["/web"],"Image":"nginx:1.21","State":"running","Status":"Up 1 hour"}]`)
+		case strings.HasSuffix(r.URL.Path, "/json"):
+			// compose同时通过服务名和 --network-alias 加上同一个别名时会重复
+			io.WriteString(w, `{"Id":"aaa111","Name":"/web","State":{"Status":"running","Running":true},
+				"NetworkSettings":{"Networks":{"app":{"Aliases":["web","aaa111","web","frontend"]}}}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	// 重复的时间序列会导致pedantic registry采集失败
+	families := gather(t, testExporter("state", "network-info"))
+	aliases := families["container_network_alias_info"]
+	if aliases == nil {
+		t.Fatal("container_network_alias_info missing")
+	}
+	var got []string
+	for _, m := range aliases.GetMetric() {
+		got = append(got, labelsOf(m)["alias"])
+	}
+	if strings.Join(got, ",") != "frontend,web" {
+		t.Errorf("aliases = %v, want [frontend web]", got)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+
+	"github.com/docker/docker/api/types"
+)
+
+// docker compose为创建的容器加上的项目label
+const composeProjectLabel = "com.docker.compose.project"
+
+// container_orphan 的reason
+const (
+	OrphanImageRemoved = "image_removed"
+	OrphanNoCompose    = "no_compose_project"
+)
+
+// ExistingImages 返回本机所有镜像的id，每次采集只需要一次image list请求，
+// 请求的耗时和返回的大小随镜像数量增长，与容器数量无关
+func ExistingImages() (map[string]bool, error) {
+	images, err := DockerClient.ImageList(context.Background(), types.ImageListOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]bool, len(images))
+	for _, image := range images {
+		existing[image.ID] = true
+	}
+	return existing, nil
+}
+
+// OrphanReasons 返回容器被视为遗留容器的原因：镜像已经被删除(docker rmi -f)，或者不属于任何compose项目。
+// existing为nil时(image list失败)不检查镜像
+func OrphanReasons(info types.Container, existing map[string]bool) []string {
+	var reasons []string
+	if existing != nil && info.ImageID != "" && !existing[info.ImageID] {
+		reasons = append(reasons, OrphanImageRemoved)
+	}
+	if info.Labels[composeProjectLabel] == "" {
+		reasons = append(reasons, OrphanNoCompose)
+	}
+	return reasons
+}
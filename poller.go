@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Poller 在后台定期全量刷新容器列表，采集时直接读取，每次间隔加上随机抖动，
+// 避免大量exporter使用相同的间隔时同时请求docker
+type Poller struct {
+	store    *ContainerStore
+	interval time.Duration
+	// 抖动占interval的比例，0表示不抖动
+	jitter float64
+	rand   *rand.Rand
+}
+
+func NewPoller(store *ContainerStore, interval time.Duration, jitter float64, seed int64) *Poller {
+	store.polled = true
+	return &Poller{
+		store:    store,
+		interval: interval,
+		jitter:   jitter,
+		rand:     rand.New(rand.NewSource(seed)),
+	}
+}
+
+// NextInterval 返回下一次刷新前等待的时间，范围为 [interval, interval*(1+jitter))
+func (p *Poller) NextInterval() time.Duration {
+	if p.jitter <= 0 {
+		return p.interval
+	}
+	return p.interval + time.Duration(p.rand.Float64()*p.jitter*float64(p.interval))
+}
+
+// Run 立即刷新一次，之后按间隔刷新直到ctx取消
+func (p *Poller) Run(ctx context.Context) {
+	for {
+		if err := p.store.Connected(ctx); err != nil && ctx.Err() == nil {
+			errorLog.Printf("poll", "poll container list err, %v", err)
+			p.store.Disconnected(err)
+		} else if err == nil {
+			errorLog.Recovered("poll", "poll container list recovered")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.NextInterval()):
+		}
+	}
+}
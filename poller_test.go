@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPolledStoreSeenAt(t *testing.T) {
+	fakeDocker(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `[{"Id":"aaa","Names":["/web"],"State":"running"}]`)
+	}))
+	store := NewContainerStore()
+	NewPoller(store, time.Minute, 0, 1)
+
+	before := time.Now()
+	if err := store.Connected(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	after := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	// 刷新之后SeenAt保持为刷新完成的时间，而不是采集时的当前时间
+	seenAt := store.SeenAt()
+	if seenAt.Before(before) || seenAt.After(after) {
+		t.Errorf("SeenAt = %v, want the poll completion time in [%v, %v]", seenAt, before, after)
+	}
+	// 刷新失败时容器仍然是上一次刷新时确认存在的
+	store.Disconnected(errors.New("connection refused"))
+	if got := store.SeenAt(); !got.Equal(seenAt) {
+		t.Errorf("SeenAt after a failed poll = %v, want %v", got, seenAt)
+	}
+}
+
+func TestEventStoreSeenAt(t *testing.T) {
+	store := useContainers(t)
+	before := time.Now()
+	if seenAt := store.SeenAt(); seenAt.Before(before) {
+		t.Errorf("SeenAt of a connected event store = %v, want now", seenAt)
+	}
+}
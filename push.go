@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// Pusher 定期把采集结果推送到Pushgateway，用于无法被Prometheus抓取的主机
+type Pusher struct {
+	pusher   *push.Pusher
+	interval time.Duration
+}
+
+// NewPusher 按job和instance分组，每次推送都替换整个分组，已经消失的容器不会残留在Pushgateway中
+func NewPusher(url, job, instance string, gatherer prometheus.Gatherer, interval time.Duration) *Pusher {
+	return &Pusher{
+		// 超时不超过推送间隔，避免Pushgateway无响应时推送堆积
+		pusher:   push.New(url, job).Grouping("instance", instance).Gatherer(gatherer).Client(&http.Client{Timeout: interval}),
+		interval: interval,
+	}
+}
+
+// Run 启动后立即推送一次，之后每隔interval推送一次，ctx取消时返回
+func (p *Pusher) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		if err := p.pusher.Push(); err != nil {
+			errorLog.Printf("push", "push metrics to the pushgateway err, %v", err)
+		} else {
+			errorLog.Recovered("push", "push metrics to the pushgateway recovered")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
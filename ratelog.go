@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// RateLimitedLogger 按key限制重复的错误日志：第一次出现时立即打印，问题持续期间每个interval最多打印一次，
+// 并带上期间被丢弃的条数，问题恢复时打印一条恢复日志
+type RateLimitedLogger struct {
+	interval time.Duration
+	now      func() time.Time
+
+	mu     sync.Mutex
+	active map[string]*rateLimitedEntry
+	// 容器相关的key在容器删除后不会再出现，定期清理长时间没有出现的key
+	prunedAt time.Time
+}
+
+type rateLimitedEntry struct {
+	loggedAt   time.Time
+	suppressed int
+}
+
+func NewRateLimitedLogger(interval time.Duration) *RateLimitedLogger {
+	return &RateLimitedLogger{
+		interval: interval,
+		now:      time.Now,
+		active:   map[string]*rateLimitedEntry{},
+	}
+}
+
+// Printf 打印key对应的错误，距离上一次打印不足interval时丢弃
+func (l *RateLimitedLogger) Printf(key, format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if now.Sub(l.prunedAt) >= l.interval {
+		for k, entry := range l.active {
+			if now.Sub(entry.loggedAt) >= 2*l.interval {
+				delete(l.active, k)
+			}
+		}
+		l.prunedAt = now
+	}
+	entry, ok := l.active[key]
+	if !ok {
+		l.active[key] = &rateLimitedEntry{loggedAt: now}
+		log.Printf(format, v...)
+		return
+	}
+	if now.Sub(entry.loggedAt) < l.interval {
+		entry.suppressed++
+		return
+	}
+	msg := fmt.Sprintf(format, v...)
+	if entry.suppressed > 0 {
+		msg = fmt.Sprintf("%s (%d similar messages suppressed in the last %s)", msg, entry.suppressed, now.Sub(entry.loggedAt).Round(time.Second))
+	}
+	log.Print(msg)
+	entry.loggedAt, entry.suppressed = now, 0
+}
+
+// Recovered key对应的错误之前出现过时打印恢复日志，之后再出错会立即打印
+func (l *RateLimitedLogger) Recovered(key, format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.active[key]; !ok {
+		return
+	}
+	delete(l.active, key)
+	log.Printf(format, v...)
+}
+
+// errorLog 所有docker请求共用的限流日志，问题持续期间每个key每分钟最多打印一次
+var errorLog = NewRateLimitedLogger(time.Minute)
+
+// debugLog 设置了 --log-debug 时打印日志，用于预期会出现、通常无需处理的情况
+func debugLog(format string, v ...interface{}) {
+	if *logDebug {
+		log.Printf(format, v...)
+	}
+}
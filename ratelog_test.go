@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureLog 在测试期间把标准日志写到返回的buffer中
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestRateLimitedLogger(t *testing.T) {
+	buf := captureLog(t)
+	now := time.Unix(1600000000, 0)
+	l := NewRateLimitedLogger(time.Minute)
+	l.now = func() time.Time { return now }
+
+	// 窗口内只打印第一次
+	for i := 0; i < 5; i++ {
+		l.Printf("collect", "connection refused %d", i)
+		now = now.Add(10 * time.Second)
+	}
+	if got := strings.Count(buf.String(), "connection refused"); got != 1 {
+		t.Fatalf("logged %d times within the window, want 1:\n%s", got, buf)
+	}
+	// 其他key不受影响
+	l.Printf("inspect:aaa", "inspect err")
+	if !strings.Contains(buf.String(), "inspect err") {
+		t.Error("a different key should be logged immediately")
+	}
+
+	// 超过窗口后打印一次，并带上被丢弃的条数
+	now = now.Add(10 * time.Second)
+	buf.Reset()
+	l.Printf("collect", "connection refused %d", 5)
+	if !strings.Contains(buf.String(), "connection refused 5 (4 similar messages suppressed in the last 1m0s)") {
+		t.Errorf("after the window got %q", buf)
+	}
+
+	// 恢复后打印恢复日志，再次出错立即打印
+	buf.Reset()
+	l.Recovered("collect", "recovered")
+	l.Recovered("collect", "recovered twice")
+	l.Printf("collect", "connection refused again")
+	if got := buf.String(); !strings.Contains(got, "recovered\n") || strings.Contains(got, "recovered twice") || !strings.Contains(got, "connection refused again") {
+		t.Errorf("after recovery got %q", got)
+	}
+}
+
+// /metrics 和 /metrics/extended 的Exporter同时失败时都要打印错误
+func TestCollectErrorLogPerExporter(t *testing.T) {
+	buf := captureLog(t)
+	old := errorLog
+	errorLog = NewRateLimitedLogger(time.Minute)
+	t.Cleanup(func() { errorLog = old })
+	fakeDocker(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message":"boom"}`, http.StatusInternalServerError)
+	}))
+	basic, extended := testExporter("state"), testExporter("images")
+	for i := 0; i < 3; i++ {
+		gather(t, basic)
+		gather(t, extended)
+	}
+	if got := strings.Count(buf.String(), "boom"); got != 2 {
+		t.Errorf("logged %d collect errors, want one per exporter:\n%s", got, buf)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Readiness 记录exporter是否已经成功获取过一次容器列表
+type Readiness struct {
+	grace time.Duration
+	// 成功后置为1，之后不再变回0
+	ready int32
+}
+
+func NewReadiness(grace time.Duration) *Readiness {
+	return &Readiness{grace: grace}
+}
+
+func (r *Readiness) Ready() bool {
+	return atomic.LoadInt32(&r.ready) == 1
+}
+
+func (r *Readiness) MarkReady() {
+	atomic.StoreInt32(&r.ready, 1)
+}
+
+// ServeHTTP 处理 /healthz，还没有成功过时主动获取一次容器列表，避免编排系统在ready之前不转发抓取请求而一直等不到ready。
+// --startup-grace 内返回starting，超过之后docker仍不可用返回unavailable
+func (r *Readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !r.Ready() {
+		if _, err := GetContainerList(); err == nil {
+			r.MarkReady()
+		}
+	}
+	switch {
+	case r.Ready():
+		w.Write([]byte("ok\n"))
+	case time.Since(startTime) < r.grace:
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("starting\n"))
+	default:
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("unavailable\n"))
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// RelabelConfig Prometheus relabel_configs的一个子集，作用于容器，在输出指标前执行。
+// source_labels 可以是 name、id、image、state、status 或 label.<容器label>，
+// replace 的 target_label 可以是 name、image 或 status
+type RelabelConfig struct {
+	SourceLabels []string `yaml:"source_labels"`
+	Separator    string   `yaml:"separator"`
+	Regex        string   `yaml:"regex"`
+	TargetLabel  string   `yaml:"target_label"`
+	Replacement  string   `yaml:"replacement"`
+	// keep、drop或replace，默认为replace
+	Action string `yaml:"action"`
+
+	regex *regexp.Regexp
+}
+
+var relabelTargets = map[string]bool{"name": true, "image": true, "status": true}
+
+// Init 检查配置并填充默认值
+func (c *RelabelConfig) Init() error {
+	if c.Separator == "" {
+		c.Separator = ";"
+	}
+	if c.Regex == "" {
+		c.Regex = "(.*)"
+	}
+	if c.Replacement == "" {
+		c.Replacement = "$1"
+	}
+	if c.Action == "" {
+		c.Action = "replace"
+	}
+	if len(c.SourceLabels) == 0 {
+		return fmt.Errorf("relabel config needs source_labels")
+	}
+	for _, label := range c.SourceLabels {
+		if _, ok := relabelTargets[label]; !ok && label != "id" && label != "state" && !strings.HasPrefix(label, "label.") {
+			return fmt.Errorf("unknown source label %q", label)
+		}
+	}
+	switch c.Action {
+	case "keep", "drop":
+	case "replace":
+		if !relabelTargets[c.TargetLabel] {
+			return fmt.Errorf("invalid target_label %q, must be name, image or status", c.TargetLabel)
+		}
+	default:
+		return fmt.Errorf("unknown relabel action %q", c.Action)
+	}
+	regex, err := regexp.Compile("^(?:" + c.Regex + ")$")
+	if err != nil {
+		return fmt.Errorf("invalid relabel regex %q: %w", c.Regex, err)
+	}
+	c.regex = regex
+	return nil
+}
+
+// RelabelTarget 指标中可以被relabel改写的值
+type RelabelTarget struct {
+	Name   string
+	Image  string
+	Status string
+}
+
+func (t *RelabelTarget) get(label string, info types.Container) string {
+	switch label {
+	case "name":
+		return t.Name
+	case "image":
+		return t.Image
+	case "status":
+		return t.Status
+	case "id":
+		return info.ID
+	case "state":
+		return info.State
+	}
+	return info.Labels[strings.TrimPrefix(label, "label.")]
+}
+
+func (t *RelabelTarget) set(label, value string) {
+	switch label {
+	case "name":
+		t.Name = value
+	case "image":
+		t.Image = value
+	case "status":
+		t.Status = value
+	}
+}
+
+// Relabel 依次执行relabel规则，容器被keep/drop规则过滤掉时返回false
+func Relabel(configs []*RelabelConfig, info types.Container, target *RelabelTarget) bool {
+	for _, c := range configs {
+		values := make([]string, len(c.SourceLabels))
+		for i, label := range c.SourceLabels {
+			values[i] = target.get(label, info)
+		}
+		value := strings.Join(values, c.Separator)
+		switch c.Action {
+		case "keep":
+			if !c.regex.MatchString(value) {
+				return false
+			}
+		case "drop":
+			if c.regex.MatchString(value) {
+				return false
+			}
+		case "replace":
+			if match := c.regex.FindStringSubmatchIndex(value); match != nil {
+				target.set(c.TargetLabel, string(c.regex.ExpandString(nil, c.Replacement, value, match)))
+			}
+		}
+	}
+	return true
+}
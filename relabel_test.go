@@ -0,0 +1,131 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"gopkg.in/yaml.v2"
+)
+
+// parseRelabel 解析一段YAML格式的relabel_configs
+func parseRelabel(t *testing.T, content string) []*RelabelConfig {
+	t.Helper()
+	var configs []*RelabelConfig
+	if err := yaml.UnmarshalStrict([]byte(content), &configs); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range configs {
+		if err := c.Init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return configs
+}
+
+func TestRelabel(t *testing.T) {
+	info := types.Container{
+		ID:     "abc123",
+		State:  "running",
+		Labels: map[string]string{"com.docker.compose.project": "shop", "com.docker.compose.service": "web"},
+	}
+	tests := []struct {
+		name   string
+		rules  string
+		keep   bool
+		target RelabelTarget
+	}{
+		{
+			name:   "keep matching",
+			rules:  `[{source_labels: [label.com.docker.compose.project], regex: shop, action: keep}]`,
+			keep:   true,
+			target: RelabelTarget{Name: "shop_web_1", Image: "nginx:1.21", Status: "Up 1 hour"},
+		},
+		{
+			name:  "keep not matching",
+			rules: `[{source_labels: [label.com.docker.compose.project], regex: blog, action: keep}]`,
+		},
+		{
+			name:  "keep missing label",
+			rules: `[{source_labels: [label.team], regex: .+, action: keep}]`,
+		},
+		{
+			name:  "drop matching",
+			rules: `[{source_labels: [state, name], regex: "running;shop_.*", action: drop}]`,
+		},
+		{
+			name:   "drop not matching",
+			rules:  `[{source_labels: [state], regex: exited, action: drop}]`,
+			keep:   true,
+			target: RelabelTarget{Name: "shop_web_1", Image: "nginx:1.21", Status: "Up 1 hour"},
+		},
+		{
+			name: "replace with groups",
+			rules: `[{source_labels: [label.com.docker.compose.project, label.com.docker.compose.service],
+				separator: "/", regex: "(.+)/(.+)", target_label: name, replacement: "$1-$2"}]`,
+			keep:   true,
+			target: RelabelTarget{Name: "shop-web", Image: "nginx:1.21", Status: "Up 1 hour"},
+		},
+		{
+			name:   "replace not matching keeps the value",
+			rules:  `[{source_labels: [image], regex: "redis:.*", target_label: image, replacement: redis}]`,
+			keep:   true,
+			target: RelabelTarget{Name: "shop_web_1", Image: "nginx:1.21", Status: "Up 1 hour"},
+		},
+		{
+			name: "rules run in order",
+			rules: `[{source_labels: [image], regex: "([^:]+):.*", target_label: image},
+				{source_labels: [image], regex: nginx, action: keep}]`,
+			keep:   true,
+			target: RelabelTarget{Name: "shop_web_1", Image: "nginx", Status: "Up 1 hour"},
+		},
+	}
+	for _, tt := range tests {
+		target := RelabelTarget{Name: "shop_web_1", Image: "nginx:1.21", Status: "Up 1 hour"}
+		keep := Relabel(parseRelabel(t, tt.rules), info, &target)
+		if keep != tt.keep {
+			t.Errorf("%s: Relabel = %v, want %v", tt.name, keep, tt.keep)
+			continue
+		}
+		if keep && target != tt.target {
+			t.Errorf("%s: target = %+v, want %+v", tt.name, target, tt.target)
+		}
+	}
+}
+
+func TestRelabelConfigInit(t *testing.T) {
+	tests := []struct {
+		config RelabelConfig
+		err    string
+	}{
+		{RelabelConfig{}, "needs source_labels"},
+		{RelabelConfig{SourceLabels: []string{"hostname"}, Action: "keep"}, `unknown source label "hostname"`},
+		{RelabelConfig{SourceLabels: []string{"name"}, TargetLabel: "id"}, `invalid target_label "id"`},
+		{RelabelConfig{SourceLabels: []string{"name"}, Action: "hashmod"}, `unknown relabel action "hashmod"`},
+		{RelabelConfig{SourceLabels: []string{"name"}, Action: "keep", Regex: "("}, "invalid relabel regex"},
+	}
+	for _, tt := range tests {
+		err := tt.config.Init()
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Init(%+v) = %v, want error containing %q", tt.config, err, tt.err)
+		}
+	}
+}
+
+func TestRelabelExporter(t *testing.T) {
+	useContainers(t,
+		types.Container{ID: "aaa", Names: []string{"/shop_web_1"}, Image: "nginx:1.21", State: "running", Status: "Up 1 hour"},
+		types.Container{ID: "bbb", Names: []string{"/buildkit"}, Image: "moby/buildkit", State: "running", Status: "Up 1 hour"})
+	configs := parseRelabel(t, `
+- {source_labels: [name], regex: buildkit, action: drop}
+- {source_labels: [name], regex: "shop_(.+)_[0-9]+", target_label: name}
+`)
+	exporter := NewExporter(&Config{RelabelConfigs: configs}, map[string]bool{"state": true})
+	runState := gather(t, exporter)["container_run_state"]
+	if runState == nil || len(runState.GetMetric()) != 1 {
+		t.Fatalf("container_run_state = %v, want one container", runState)
+	}
+	if labels := labelsOf(runState.GetMetric()[0]); labels["name"] != "web" || labels["id"] != "aaa" {
+		t.Errorf("container_run_state labels = %v, want name=web id=aaa", labels)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import "sync"
+
+// RestartDeltas 按容器名累计exporter启动以来观察到的重启次数，通过对比每次采集时inspect的RestartCount得到。
+// RestartCount在容器重建后从0开始，手动启动时也会清零，按名称累计可以得到单调递增、适合rate()的计数
+type RestartDeltas struct {
+	mu         sync.Mutex
+	containers map[string]*restartDelta
+}
+
+type restartDelta struct {
+	id           string
+	restartCount int
+	total        int
+}
+
+func NewRestartDeltas() *RestartDeltas {
+	return &RestartDeltas{containers: map[string]*restartDelta{}}
+}
+
+// RestartScrape 一次采集列出的容器名，并发的采集各自使用自己的RestartScrape
+type RestartScrape struct {
+	deltas   *RestartDeltas
+	listed   map[string]bool
+	collided map[string]bool
+}
+
+// Begin 开始一次采集，names为本次输出的所有容器名。多个容器同名时id交替出现，会被当作不断重建而虚增计数，
+// 这些名称本次不再累计，已有的记录也丢弃
+func (d *RestartDeltas) Begin(names []string) *RestartScrape {
+	s := &RestartScrape{deltas: d, listed: make(map[string]bool, len(names)), collided: map[string]bool{}}
+	for _, name := range names {
+		if s.listed[name] {
+			s.collided[name] = true
+		}
+		s.listed[name] = true
+	}
+	d.mu.Lock()
+	for name := range s.collided {
+		delete(d.containers, name)
+	}
+	d.mu.Unlock()
+	return s
+}
+
+// Prune 删除本次采集没有列出的容器名，只应在容器列表完整时调用
+func (s *RestartScrape) Prune() {
+	d := s.deltas
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for name := range d.containers {
+		if !s.listed[name] {
+			delete(d.containers, name)
+		}
+	}
+}
+
+// Observe 记录容器本次采集时的RestartCount，返回该名称累计的重启次数，名称与其他容器重复时ok为false。
+// 第一次看到的名称不计入启动前的重启，之后出现的新id(容器被重建)或RestartCount变小时，当前的RestartCount全部计入
+func (s *RestartScrape) Observe(name, id string, restartCount int) (total int, ok bool) {
+	if s.collided[name] {
+		return 0, false
+	}
+	d := s.deltas
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delta, ok := d.containers[name]
+	switch {
+	case !ok:
+		delta = &restartDelta{id: id}
+		d.containers[name] = delta
+	case delta.id != id:
+		delta.id = id
+		delta.total += restartCount
+	case restartCount >= delta.restartCount:
+		delta.total += restartCount - delta.restartCount
+	default:
+		// 手动启动清零后又被策略重启过
+		delta.total += restartCount
+	}
+	delta.restartCount = restartCount
+	return delta.total, true
+}
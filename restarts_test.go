@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+func TestRestartDeltasRecreation(t *testing.T) {
+	d := NewRestartDeltas()
+	// scrape 列出一次web并记录RestartCount，返回累计的重启次数
+	scrape := func(id string, restartCount int) int {
+		t.Helper()
+		s := d.Begin([]string{"web"})
+		total, ok := s.Observe("web", id, restartCount)
+		if !ok {
+			t.Fatalf("Observe(web, %s) not ok", id)
+		}
+		s.Prune()
+		return total
+	}
+
+	// 第一次看到时不计入启动前的重启
+	if got := scrape("id1", 3); got != 0 {
+		t.Errorf("first observation = %d, want 0", got)
+	}
+	if got := scrape("id1", 5); got != 2 {
+		t.Errorf("after 2 restarts = %d, want 2", got)
+	}
+	// compose重建容器，新id的RestartCount从0开始
+	if got := scrape("id2", 1); got != 3 {
+		t.Errorf("after recreation = %d, want 3", got)
+	}
+	// 手动重启清零
+	if got := scrape("id2", 0); got != 3 {
+		t.Errorf("after manual restart = %d, want 3", got)
+	}
+
+	// 容器删除后记录被清理，同名的新容器重新开始计数
+	d.Begin(nil).Prune()
+	if len(d.containers) != 0 {
+		t.Errorf("%d entries left after the container disappeared", len(d.containers))
+	}
+	if got := scrape("id3", 4); got != 0 {
+		t.Errorf("recreated after removal = %d, want 0", got)
+	}
+}
+
+func TestRestartDeltasCollision(t *testing.T) {
+	d := NewRestartDeltas()
+	d.Begin([]string{"web"}).Observe("web", "id1", 0)
+
+	// relabel把两个容器改成同一个名称，id交替出现不应计为重建
+	for i := 0; i < 3; i++ {
+		s := d.Begin([]string{"web", "web"})
+		for _, id := range []string{"id1", "id2"} {
+			if total, ok := s.Observe("web", id, 2); ok {
+				t.Errorf("Observe of a colliding name returned %d, want not ok", total)
+			}
+		}
+		s.Prune()
+	}
+	// 冲突消失后重新开始计数
+	if total, ok := d.Begin([]string{"web"}).Observe("web", "id1", 2); !ok || total != 0 {
+		t.Errorf("after the collision Observe = %d, %v, want 0, true", total, ok)
+	}
+}
+
+func TestRestartDeltasOverlappingScrapes(t *testing.T) {
+	d := NewRestartDeltas()
+	d.Begin([]string{"web"}).Observe("web", "id1", 0)
+
+	// 推送和HTTP抓取同时采集到同一个RestartCount，不会重复累计
+	push, scrape := d.Begin([]string{"web"}), d.Begin([]string{"web", "api"})
+	scrape.Observe("web", "id1", 1)
+	push.Observe("web", "id1", 1)
+	scrape.Prune()
+	push.Prune()
+	if total, _ := d.Begin([]string{"web"}).Observe("web", "id1", 1); total != 1 {
+		t.Errorf("after overlapping scrapes total = %d, want 1", total)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// Snapshot 保存最后一次成功采集到的容器列表，--serve-stale 时在docker不可用期间代替空列表输出
+type Snapshot struct {
+	maxStaleness time.Duration
+
+	mu            sync.Mutex
+	containerList []types.Container
+	updatedAt     time.Time
+}
+
+func NewSnapshot(maxStaleness time.Duration) *Snapshot {
+	return &Snapshot{maxStaleness: maxStaleness}
+}
+
+// Update 采集成功时保存列表并原样返回；失败且没有拿到任何容器时返回未超过maxStaleness的旧列表，
+// 同时返回所用列表距今的时长，没有可用列表时ok为false
+func (s *Snapshot) Update(containerList []types.Container, err error, now time.Time) (result []types.Container, age time.Duration, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		s.containerList, s.updatedAt = containerList, now
+		return containerList, 0, true
+	}
+	if len(containerList) > 0 || s.updatedAt.IsZero() {
+		return containerList, 0, len(containerList) > 0
+	}
+	if age = now.Sub(s.updatedAt); age > s.maxStaleness {
+		return nil, age, false
+	}
+	return s.containerList, age, true
+}
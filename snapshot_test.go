@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestServeStaleSkipsInspectAndStats(t *testing.T) {
+	setFlag(t, "serve-stale", "true")
+	var down, inspects, stats int32
+	fakeDocker(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			if atomic.LoadInt32(&down) == 1 {
+				http.Error(w, `{"message":"daemon is restarting"}`, http.StatusServiceUnavailable)
+				return
+			}
+			io.WriteString(w, `[{"Id":"aaa","Names":["/web"],"Image":"nginx:1.21","State":"running","Status":"Up 1 hour"}]`)
+		case strings.HasSuffix(r.URL.Path, "/stats"):
+			atomic.AddInt32(&stats, 1)
+			io.WriteString(w, `{}`)
+		case strings.HasSuffix(r.URL.Path, "/json"):
+			atomic.AddInt32(&inspects, 1)
+			io.WriteString(w, `{"Id":"aaa","Name":"/web","State":{"Status":"running","Running":true}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	e := testExporter("state", "inspect", "stats")
+
+	gather(t, e)
+	if atomic.LoadInt32(&inspects) == 0 || atomic.LoadInt32(&stats) == 0 {
+		t.Fatalf("healthy scrape: inspects=%d stats=%d, want both > 0", inspects, stats)
+	}
+
+	atomic.StoreInt32(&down, 1)
+	atomic.StoreInt32(&inspects, 0)
+	atomic.StoreInt32(&stats, 0)
+	families := gather(t, e)
+	if m := findMetric(families["container_run_state"], map[string]string{"name": "web"}); m == nil {
+		t.Fatal("stale scrape: container_run_state{name=web} missing")
+	}
+	if n := atomic.LoadInt32(&inspects); n != 0 {
+		t.Errorf("stale scrape inspected %d containers, want 0", n)
+	}
+	if n := atomic.LoadInt32(&stats); n != 0 {
+		t.Errorf("stale scrape fetched stats of %d containers, want 0", n)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/docker/docker/api/types"
+)
+
+// LoadContainerFile 读取 --source-file 指定的容器列表，格式与 GET /containers/json 的返回相同，
+// 每次采集都重新读取，修改文件后下一次抓取即可生效
+func LoadContainerFile(path string) ([]types.Container, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read source file %s: %w", path, err)
+	}
+	// 新版本daemon的返回中有types.Container没有的字段，忽略即可
+	var containerList []types.Container
+	if err := json.Unmarshal(content, &containerList); err != nil {
+		return nil, fmt.Errorf("parse source file %s, expected a JSON array of containers: %w", path, err)
+	}
+	for i, container := range containerList {
+		if container.ID == "" || len(container.Names) == 0 {
+			return nil, fmt.Errorf("parse source file %s: container %d must have Id and Names", path, i)
+		}
+	}
+	return containerList, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadContainerFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		ok      bool
+	}{
+		{
+			// 新版本daemon返回的字段
+			name: "current daemon",
+			content: `[{"Id":"aaa","Names":["/web"],"Image":"nginx","State":"running",
+				"ImageManifestDescriptor":{"mediaType":"application/vnd.oci.image.manifest.v1+json"},
+				"NetworkSettings":{"Networks":{"bridge":{"DNSNames":["web"]}}}}]`,
+			ok: true,
+		},
+		{name: "empty", content: `[]`, ok: true},
+		{name: "missing id", content: `[{"Names":["/web"]}]`},
+		{name: "missing names", content: `[{"Id":"aaa"}]`},
+		{name: "not an array", content: `{"Id":"aaa","Names":["/web"]}`},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "containers.json")
+		if err := ioutil.WriteFile(path, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		containers, err := LoadContainerFile(path)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: LoadContainerFile error = %v, want ok=%v", tt.name, err, tt.ok)
+			continue
+		}
+		if tt.name == "current daemon" && (len(containers) != 1 || containers[0].ID != "aaa") {
+			t.Errorf("%s: got %+v", tt.name, containers)
+		}
+	}
+}
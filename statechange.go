@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// StateHistory 记录每个容器的状态和最近一次状态变化，用于发现两次采集之间发生的状态变化。
+// 变化在window内的每次采集中都会输出，push、/metrics.json以及多个Prometheus副本的采集不会互相吞掉
+type StateHistory struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	records map[string]stateRecord
+}
+
+type stateRecord struct {
+	state string
+	// 最近一次变化之前的状态和变化被发现的时间，没有变化或已超出window时from为空
+	from      string
+	changedAt time.Time
+}
+
+func NewStateHistory(window time.Duration) *StateHistory {
+	return &StateHistory{
+		window:  window,
+		now:     time.Now,
+		records: map[string]stateRecord{},
+	}
+}
+
+// StateScrape 一次采集中观察到的状态，并发的采集各自使用自己的StateScrape
+type StateScrape struct {
+	history *StateHistory
+	at      time.Time
+	records map[string]stateRecord
+}
+
+// Begin 开始一次采集
+func (h *StateHistory) Begin() *StateScrape {
+	return &StateScrape{history: h, at: h.now(), records: map[string]stateRecord{}}
+}
+
+// Observe 记录容器本次采集时的状态，window内发生过变化时返回变化前的状态和变化的时间。
+// 第一次看到的容器不算变化
+func (s *StateScrape) Observe(id, state string) (from string, changedAt time.Time, changed bool) {
+	h := s.history
+	h.mu.Lock()
+	record, ok := h.records[id]
+	h.mu.Unlock()
+	switch {
+	case !ok:
+		record = stateRecord{state: state}
+	case record.state != state:
+		record = stateRecord{state: state, from: record.state, changedAt: s.at}
+	case record.from != "" && s.at.Sub(record.changedAt) > h.window:
+		record = stateRecord{state: state}
+	}
+	s.records[id] = record
+	return record.from, record.changedAt, record.from != ""
+}
+
+// Commit 用本次采集的记录替换之前的记录，已经消失的容器随之删除，只应在容器列表完整时调用
+func (s *StateScrape) Commit() {
+	s.history.mu.Lock()
+	s.history.records = s.records
+	s.history.mu.Unlock()
+}
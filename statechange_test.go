@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestStateChangedRunningToExited(t *testing.T) {
+	store := useContainers(t, types.Container{ID: "aaa", Names: []string{"/web"}, Image: "nginx:1.21", State: "running", Status: "Up 1 hour"})
+	setFlag(t, "state-change-window", "5m")
+	e := testExporter("state")
+	now := time.Unix(1600000000, 0)
+	e.stateHistory.now = func() time.Time { return now }
+
+	changed := func() *float64 {
+		t.Helper()
+		m := findMetric(gather(t, e)["container_state_changed"], map[string]string{"name": "web", "from_state": "running", "to_state": "exited"})
+		if m == nil {
+			return nil
+		}
+		value := metricValue(m)
+		return &value
+	}
+
+	// 第一次看到容器不算变化
+	if v := changed(); v != nil {
+		t.Fatalf("first scrape reported a change at %v", *v)
+	}
+	now = now.Add(15 * time.Second)
+	store.containers["aaa"] = types.Container{ID: "aaa", Names: []string{"/web"}, Image: "nginx:1.21", State: "exited", Status: "Exited (1) 1 second ago"}
+	changedAt := float64(now.Unix())
+	if v := changed(); v == nil || *v != changedAt {
+		t.Fatalf("scrape after the change = %v, want %v", v, changedAt)
+	}
+	// push、其他Prometheus副本等在window内的其他采集也能看到，值保持为变化的时间
+	for i := 0; i < 3; i++ {
+		now = now.Add(time.Minute)
+		if v := changed(); v == nil || *v != changedAt {
+			t.Fatalf("scrape %d within the window = %v, want %v", i, v, changedAt)
+		}
+	}
+	now = now.Add(3 * time.Minute)
+	if v := changed(); v != nil {
+		t.Errorf("scrape after the window still reports the change at %v", *v)
+	}
+}
+
+func TestStateHistoryIncompleteList(t *testing.T) {
+	h := NewStateHistory(time.Minute)
+	scrape := h.Begin()
+	scrape.Observe("aaa", "running")
+	scrape.Commit()
+
+	// 列表不完整时不提交，本次缺少的容器不会被当作新容器
+	h.Begin().Observe("bbb", "running")
+	if _, _, changed := h.Begin().Observe("aaa", "exited"); !changed {
+		t.Error("change after an uncommitted scrape not reported")
+	}
+}
+
+func TestStateHistoryOverlappingScrapes(t *testing.T) {
+	h := NewStateHistory(time.Minute)
+	first := h.Begin()
+	first.Observe("aaa", "running")
+	first.Commit()
+
+	// HTTP抓取和推送同时采集，各自的记录互不影响
+	push, scrape := h.Begin(), h.Begin()
+	push.Observe("aaa", "exited")
+	if _, _, changed := scrape.Observe("aaa", "exited"); !changed {
+		t.Error("overlapping scrape missed the change")
+	}
+	scrape.Observe("bbb", "running")
+	scrape.Commit()
+	push.Commit()
+	if _, _, changed := h.Begin().Observe("aaa", "exited"); !changed {
+		t.Error("change lost after overlapping commits")
+	}
+}
+
+// swarm等与容器列表无关的请求失败时照常记录状态
+func TestStateChangedWithSwarmErrors(t *testing.T) {
+	fakeDocker(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message":"This node is not a swarm manager."}`, http.StatusServiceUnavailable)
+	}))
+	store := useContainers(t, types.Container{ID: "aaa", Names: []string{"/web"}, Image: "nginx:1.21", State: "running", Status: "Up 1 hour"})
+	e := testExporter("state", "swarm-tasks")
+
+	gather(t, e)
+	store.containers["aaa"] = types.Container{ID: "aaa", Names: []string{"/web"}, Image: "nginx:1.21", State: "exited", Status: "Exited (1) 1 second ago"}
+	families := gather(t, e)
+	if m := findMetric(families["container_exporter_scrape_success"], nil); m == nil || metricValue(m) != 0 {
+		t.Fatalf("container_exporter_scrape_success = %v, want 0 when swarm-tasks fails", m)
+	}
+	if findMetric(families["container_state_changed"], map[string]string{"name": "web", "from_state": "running", "to_state": "exited"}) == nil {
+		t.Error("container_state_changed missing when only swarm-tasks fails")
+	}
+}
+
+// --only-orphans 的镜像列表失败时照常记录状态
+func TestStateChangedWithImageListError(t *testing.T) {
+	setFlag(t, "only-orphans", "true")
+	fakeDocker(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message":"server error"}`, http.StatusInternalServerError)
+	}))
+	// 不属于compose项目，总是遗留容器
+	store := useContainers(t, types.Container{ID: "aaa", Names: []string{"/web"}, Image: "nginx:1.21", State: "running", Status: "Up 1 hour"})
+	e := testExporter("state")
+
+	gather(t, e)
+	store.containers["aaa"] = types.Container{ID: "aaa", Names: []string{"/web"}, Image: "nginx:1.21", State: "exited", Status: "Exited (1) 1 second ago"}
+	families := gather(t, e)
+	if m := findMetric(families["container_exporter_scrape_success"], nil); m == nil || metricValue(m) != 0 {
+		t.Fatalf("container_exporter_scrape_success = %v, want 0 when listing images fails", m)
+	}
+	if findMetric(families["container_state_changed"], map[string]string{"name": "web", "from_state": "running", "to_state": "exited"}) == nil {
+		t.Error("container_state_changed missing when only listing images fails")
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/errdefs"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 同时请求stats的数量，docker为了计算CPU使用率，每个容器的stats都要等待约1秒
+const statsConcurrency = 8
+
+// FetchStats 并发获取运行中容器的stats，返回容器id到stats的映射和失败的容器数量，失败的容器不在结果中
+func FetchStats(selected []selectedContainer) (map[string]*types.StatsJSON, int) {
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		stats = map[string]*types.StatsJSON{}
+		sem   = make(chan struct{}, statsConcurrency)
+		// 失败的容器数量
+		failed int
+	)
+	for _, s := range selected {
+		if GetContainerState(s.info.State) != "running" {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(id, name string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			containerStats, err := GetContainerStats(id)
+			if errdefs.IsNotFound(err) {
+				debugLog("container %s was removed before getting its stats, %v", name, err)
+				return
+			}
+			if err != nil {
+				errorLog.Printf("stats:"+id, "get stats of container %s err, %v", name, err)
+				mu.Lock()
+				failed++
+				mu.Unlock()
+				return
+			}
+			mu.Lock()
+			stats[id] = containerStats
+			mu.Unlock()
+		}(s.info.ID, s.target.Name)
+	}
+	wg.Wait()
+	return stats, failed
+}
+
+// GetContainerStats 获取容器的一次stats
+func GetContainerStats(id string) (*types.StatsJSON, error) {
+	resp, err := DockerClient.ContainerStats(context.Background(), id, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var stats types.StatsJSON
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// CPUPercent 按docker stats的方式计算两次采样之间的CPU使用率，
+// cgroup v2或采样过快时system_cpu_usage和percpu_usage可能为0或没有变化，此时返回false，避免输出NaN或Inf
+func CPUPercent(stats *types.StatsJSON) (float64, bool) {
+	cpu, precpu := stats.CPUStats, stats.PreCPUStats
+	if cpu.SystemUsage <= precpu.SystemUsage || cpu.CPUUsage.TotalUsage < precpu.CPUUsage.TotalUsage {
+		return 0, false
+	}
+	onlineCPUs := cpu.OnlineCPUs
+	if onlineCPUs == 0 {
+		onlineCPUs = uint32(len(cpu.CPUUsage.PercpuUsage))
+	}
+	if onlineCPUs == 0 {
+		return 0, false
+	}
+	cpuDelta := float64(cpu.CPUUsage.TotalUsage - precpu.CPUUsage.TotalUsage)
+	systemDelta := float64(cpu.SystemUsage - precpu.SystemUsage)
+	return cpuDelta / systemDelta * float64(onlineCPUs) * 100, true
+}
+
+// collectStats 输出stats采集器的指标
+func (e *Exporter) collectStats(ch chan<- prometheus.Metric, stats *types.StatsJSON, container types.ContainerJSON, inspected bool, name string) {
+	if percent, ok := CPUPercent(stats); ok {
+		ch <- prometheus.MustNewConstMetric(e.cpuUsagePercent, prometheus.GaugeValue, percent, name, stats.ID)
+	}
+
+	// 没有设置CPU限制的容器始终为0
+	throttling := stats.CPUStats.ThrottlingData
+	ch <- prometheus.MustNewConstMetric(e.cpuThrottledPeriods, prometheus.CounterValue, float64(throttling.ThrottledPeriods), name, stats.ID)
+	ch <- prometheus.MustNewConstMetric(e.cpuThrottledTime, prometheus.CounterValue, float64(throttling.ThrottledTime)/1e9, name, stats.ID)
+
+	// 只有开启了swap accounting的cgroup v1才有swap
+	if swap, ok := stats.MemoryStats.Stats["swap"]; ok {
+		ch <- prometheus.MustNewConstMetric(e.swapUsage, prometheus.GaugeValue, float64(swap), name, stats.ID)
+		// MemorySwap是内存加swap的总限制，-1表示不限制
+		if inspected && container.HostConfig != nil && container.HostConfig.MemorySwap > 0 {
+			limit := container.HostConfig.MemorySwap - container.HostConfig.Memory
+			ch <- prometheus.MustNewConstMetric(e.swapLimit, prometheus.GaugeValue, float64(limit), name, stats.ID)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestCPUPercent(t *testing.T) {
+	tests := []struct {
+		name string
+		// docker stats返回的JSON
+		sample string
+		want   float64
+		ok     bool
+	}{
+		{
+			name: "cgroup v1 with percpu",
+			sample: `{"cpu_stats":{"cpu_usage":{"total_usage":300,"percpu_usage":[150,150]},"system_cpu_usage":2000},
+				"precpu_stats":{"cpu_usage":{"total_usage":100,"percpu_usage":[50,50]},"system_cpu_usage":1000}}`,
+			want: 40,
+			ok:   true,
+		},
+		{
+			name: "cgroup v2 with online_cpus and no percpu",
+			sample: `{"cpu_stats":{"cpu_usage":{"total_usage":300},"system_cpu_usage":2000,"online_cpus":4},
+				"precpu_stats":{"cpu_usage":{"total_usage":100},"system_cpu_usage":1000,"online_cpus":4}}`,
+			want: 80,
+			ok:   true,
+		},
+		{
+			// 采样过快，两次system_cpu_usage相同
+			name: "cgroup v2 zero system delta",
+			sample: `{"cpu_stats":{"cpu_usage":{"total_usage":300},"system_cpu_usage":1000,"online_cpus":2},
+				"precpu_stats":{"cpu_usage":{"total_usage":100},"system_cpu_usage":1000,"online_cpus":2}}`,
+		},
+		{
+			// 第一次采样没有precpu_stats，online_cpus也没有
+			name: "cgroup v2 no percpu and no online_cpus",
+			sample: `{"cpu_stats":{"cpu_usage":{"total_usage":300},"system_cpu_usage":2000},
+				"precpu_stats":{"cpu_usage":{"total_usage":100},"system_cpu_usage":1000}}`,
+		},
+		{
+			name:   "first sample without precpu",
+			sample: `{"cpu_stats":{"cpu_usage":{"total_usage":300},"system_cpu_usage":0,"online_cpus":2}}`,
+		},
+		{
+			// 容器重启后计数归零
+			name: "total usage reset",
+			sample: `{"cpu_stats":{"cpu_usage":{"total_usage":50},"system_cpu_usage":2000,"online_cpus":2},
+				"precpu_stats":{"cpu_usage":{"total_usage":100},"system_cpu_usage":1000,"online_cpus":2}}`,
+		},
+	}
+	for _, tt := range tests {
+		var stats types.StatsJSON
+		if err := json.Unmarshal([]byte(tt.sample), &stats); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got, ok := CPUPercent(&stats)
+		if ok != tt.ok {
+			t.Errorf("%s: CPUPercent ok = %v, want %v", tt.name, ok, tt.ok)
+			continue
+		}
+		if math.IsNaN(got) || math.IsInf(got, 0) {
+			t.Errorf("%s: CPUPercent = %v", tt.name, got)
+		}
+		if ok && math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: CPUPercent = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCollectorErrorsStats(t *testing.T) {
+	fakeDocker(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			io.WriteString(w, `[{"Id":"aaa","Names":["/web"],"Image":"nginx:1.21","State":"running","Status":"Up 1 hour"},
+				{"Id":"bbb","Names":["/api"],"Image":"nginx:1.21","State":"running","Status":"Up 1 hour"}]`)
+		case strings.HasSuffix(r.URL.Path, "/stats"):
+			http.Error(w, `{"message":"cgroup not found"}`, http.StatusInternalServerError)
+		case strings.HasSuffix(r.URL.Path, "/json"):
+			io.WriteString(w, `{"Id":"aaa","State":{"Status":"running","Running":true}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	e := testExporter("state", "counts", "stats")
+	gather(t, e)
+	families := gather(t, e)
+
+	collectorErrors := families["container_exporter_collector_errors_total"]
+	// 每个容器每次采集失败一次，其他采集器不受影响
+	for collector, want := range map[string]float64{"stats": 4, "state": 0, "counts": 0} {
+		m := findMetric(collectorErrors, map[string]string{"collector": collector})
+		if m == nil || metricValue(m) != want {
+			t.Errorf("container_exporter_collector_errors_total{collector=%q} = %v, want %v", collector, m, want)
+		}
+	}
+	if families["container_cpu_usage_percent"] != nil {
+		t.Error("container_cpu_usage_percent should not be reported when stats fail")
+	}
+	if m := findMetric(families["container_run_state"], map[string]string{"name": "web"}); m == nil {
+		t.Error("container_run_state{name=web} missing when stats fail")
+	}
+}
+
+func TestCPUThrottling(t *testing.T) {
+	samples := map[string]string{
+		// 200ms被限流，单位是纳秒
+		"aaa": `{"id":"aaa","cpu_stats":{"throttling_data":{"periods":1200,"throttled_periods":35,"throttled_time":200000000}}}`,
+		// 没有CPU限制的容器
+		"bbb": `{"id":"bbb","cpu_stats":{"cpu_usage":{"total_usage":100}}}`,
+	}
+	fakeDocker(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			io.WriteString(w, `[{"Id":"aaa","Names":["/web"],"Image":"nginx:1.21","State":"running","Status":"Up 1 hour"},
+				{"Id":"bbb","Names":["/api"],"Image":"nginx:1.21","State":"running","Status":"Up 1 hour"}]`)
+		case strings.HasSuffix(r.URL.Path, "/stats"):
+			io.WriteString(w, samples[containerIDOf(r)])
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	families := gather(t, testExporter("stats"))
+
+	for metric, want := range map[string]map[string]float64{
+		"container_cpu_throttled_periods_total": {"web": 35, "api": 0},
+		"container_cpu_throttled_time_total":    {"web": 0.2, "api": 0},
+	} {
+		for name, value := range want {
+			m := findMetric(families[metric], map[string]string{"name": name})
+			if m == nil || m.GetCounter() == nil || math.Abs(metricValue(m)-value) > 1e-9 {
+				t.Errorf("%s{name=%q} = %v, want counter %v", metric, name, m, value)
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SwarmTaskStates swarm task状态对应的容器状态，用于 --swarm-tasks-as-containers
+var SwarmTaskStates = map[swarm.TaskState]string{
+	swarm.TaskStateNew:       "created",
+	swarm.TaskStateAllocated: "created",
+	swarm.TaskStatePending:   "created",
+	swarm.TaskStateAssigned:  "created",
+	swarm.TaskStateAccepted:  "created",
+	swarm.TaskStatePreparing: "created",
+	swarm.TaskStateReady:     "created",
+	swarm.TaskStateStarting:  "created",
+	swarm.TaskStateRunning:   "running",
+	swarm.TaskStateComplete:  "exited",
+	swarm.TaskStateShutdown:  "exited",
+	swarm.TaskStateFailed:    "exited",
+	swarm.TaskStateRejected:  "exited",
+	swarm.TaskStateRemove:    "removing",
+	swarm.TaskStateOrphaned:  "dead",
+}
+
+// collectSwarmTasks 在swarm manager上输出整个集群中期望运行的task，值为1表示实际状态已达到期望状态，
+// 节点宕机时task会停留在assigned等状态，node_state标签可以区分是否是节点的问题。
+// 开启 --swarm-tasks-as-containers 时同时按容器输出container_run_state并计入stateCount，
+// 容器已经在本机容器列表中(containerList)的task跳过，避免重复计数
+func (e *Exporter) collectSwarmTasks(ch chan<- prometheus.Metric, containerList []types.Container, stateCount map[string]int) error {
+	ctx := context.Background()
+	nodes, err := DockerClient.NodeList(ctx, types.NodeListOptions{})
+	if err != nil {
+		return err
+	}
+	services, err := DockerClient.ServiceList(ctx, types.ServiceListOptions{})
+	if err != nil {
+		return err
+	}
+	tasks, err := DockerClient.TaskList(ctx, types.TaskListOptions{
+		Filters: filters.NewArgs(filters.Arg("desired-state", string(swarm.TaskStateRunning))),
+	})
+	if err != nil {
+		return err
+	}
+
+	nodeByID := make(map[string]swarm.Node, len(nodes))
+	for _, node := range nodes {
+		nodeByID[node.ID] = node
+	}
+	serviceNames := make(map[string]string, len(services))
+	for _, service := range services {
+		serviceNames[service.ID] = service.Spec.Name
+	}
+	local := make(map[string]bool, len(containerList))
+	for _, container := range containerList {
+		local[container.ID] = true
+	}
+	for _, task := range tasks {
+		if *swarmTasksAsContainers && e.collectors["state"] && !local[TaskContainerID(task)] {
+			e.collectTaskState(ch, task, serviceNames[task.ServiceID], stateCount)
+		}
+		// 还没有分配节点的task，node为空
+		nodeName, nodeState := "", ""
+		if node, ok := nodeByID[task.NodeID]; ok {
+			nodeName, nodeState = node.Description.Hostname, string(node.Status.State)
+		}
+		ch <- prometheus.MustNewConstMetric(e.swarmTaskState, prometheus.GaugeValue,
+			boolValue(task.Status.State == task.DesiredState),
+			serviceNames[task.ServiceID], strconv.Itoa(task.Slot), task.ID, nodeName, nodeState,
+			string(task.DesiredState), string(task.Status.State))
+	}
+	return nil
+}
+
+// TaskContainerID 返回task的容器id，pending等还没有创建容器的task没有ContainerStatus，返回空
+func TaskContainerID(task swarm.Task) string {
+	if task.Status.ContainerStatus == nil {
+		return ""
+	}
+	return task.Status.ContainerStatus.ContainerID
+}
+
+// collectTaskState 把task当作容器输出container_run_state，名称与docker创建的task容器一致，即 <service>.<slot>.<task id>，
+// 还没有创建容器的task以task id作为id
+func (e *Exporter) collectTaskState(ch chan<- prometheus.Metric, task swarm.Task, serviceName string, stateCount map[string]int) {
+	state, ok := SwarmTaskStates[task.Status.State]
+	if !ok {
+		state = "UNKNOW"
+	}
+	stateCount[state]++
+	slot := strconv.Itoa(task.Slot)
+	if task.Slot == 0 {
+		// global service没有slot，docker使用节点id
+		slot = task.NodeID
+	}
+	id := task.ID
+	if containerID := TaskContainerID(task); containerID != "" {
+		id = containerID
+	}
+	image := ""
+	if task.Spec.ContainerSpec != nil {
+		image = task.Spec.ContainerSpec.Image
+	}
+	ch <- prometheus.MustNewConstMetric(e.queryDockerStatus, MetricTypes[*metricType], ContainerStatusMap[state],
+		e.appendStateLabelValues(nil, serviceName+"."+slot+"."+task.ID, id, image, string(task.Status.State), state, "swarm")...)
+}
+
+// IsSwarmManager 判断当前连接的docker daemon是否是swarm manager
+func IsSwarmManager() (bool, error) {
+	info, err := DockerClient.Info(context.Background())
+	if err != nil {
+		return false, err
+	}
+	return info.Swarm.ControlAvailable, nil
+}
+
+// DisableSwarmTasksUnlessManager 只有manager才能列出整个集群的task，不是manager时关闭swarm-tasks采集器。
+// SplitExtended返回的是副本，所有采集器集合都要去掉，否则每次采集都会请求失败
+func DisableSwarmTasksUnlessManager(collectorSets ...map[string]bool) {
+	enabled := false
+	for _, collectors := range collectorSets {
+		enabled = enabled || collectors["swarm-tasks"]
+	}
+	if !enabled {
+		return
+	}
+	manager, err := IsSwarmManager()
+	switch {
+	case err != nil:
+		log.Printf("disable the swarm-tasks collector, query docker info err, %v", err)
+	case !manager:
+		log.Printf("disable the swarm-tasks collector, the docker daemon is not a swarm manager")
+	default:
+		return
+	}
+	for _, collectors := range collectorSets {
+		delete(collectors, "swarm-tasks")
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSwarmTasksAsContainers(t *testing.T) {
+	setFlag(t, "swarm-tasks-as-containers", "true")
+	fakeDocker(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			io.WriteString(w, `[{"Id":"c-local","Names":["/web.1.t1"],"Image":"nginx:1.21","State":"running","Status":"Up 1 hour"}]`)
+		case strings.HasSuffix(r.URL.Path, "/nodes"):
+			io.WriteString(w, `[{"ID":"n1","Description":{"Hostname":"node-1"},"Status":{"State":"ready"}},
+				{"ID":"n2","Description":{"Hostname":"node-2"},"Status":{"State":"ready"}}]`)
+		case strings.HasSuffix(r.URL.Path, "/services"):
+			io.WriteString(w, `[{"ID":"s1","Spec":{"Name":"web"}}]`)
+		case strings.HasSuffix(r.URL.Path, "/tasks"):
+			io.WriteString(w, `[
+				{"ID":"t1","ServiceID":"s1","Slot":1,"NodeID":"n1","DesiredState":"running",
+					"Status":{"State":"running","ContainerStatus":{"ContainerID":"c-local"}}},
+				{"ID":"t2","ServiceID":"s1","Slot":2,"NodeID":"n2","DesiredState":"running",
+					"Status":{"State":"running","ContainerStatus":{"ContainerID":"c-remote"}}},
+				{"ID":"t3","ServiceID":"s1","Slot":3,"DesiredState":"running","Status":{"State":"pending"}}
+			]`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	families := gather(t, testExporter("state", "swarm-tasks"))
+
+	runState := families["container_run_state"]
+	want := map[string]map[string]string{
+		// 本机的task容器只按本机容器输出一次
+		"c-local":  {"name": "web.1.t1", "source": "local", "state": "running"},
+		"c-remote": {"name": "web.2.t2", "source": "swarm", "state": "running"},
+		// 还没有容器的task以task id作为id
+		"t3": {"name": "web.3.t3", "source": "swarm", "state": "created"},
+	}
+	if runState == nil || len(runState.GetMetric()) != len(want) {
+		t.Fatalf("container_run_state = %v, want %d series", runState, len(want))
+	}
+	for id, labels := range want {
+		labels["id"] = id
+		if findMetric(runState, labels) == nil {
+			t.Errorf("container_run_state%v missing", labels)
+		}
+	}
+	for state, count := range map[string]float64{"running": 2, "created": 1} {
+		if m := findMetric(families["container_state_count"], map[string]string{"state": state}); m == nil || metricValue(m) != count {
+			t.Errorf("container_state_count{state=%q} = %v, want %v", state, m, count)
+		}
+	}
+	if tasks := families["container_swarm_task_state"]; tasks == nil || len(tasks.GetMetric()) != 3 {
+		t.Errorf("container_swarm_task_state = %v, want 3 tasks", tasks)
+	}
+}
+
+func TestSwarmTasksOnWorker(t *testing.T) {
+	setFlag(t, "extended-collectors", "counts")
+	var nodeLists int32
+	fakeDocker(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/info"):
+			io.WriteString(w, `{"Swarm":{"NodeID":"n1","LocalNodeState":"active","ControlAvailable":false}}`)
+		case strings.HasSuffix(r.URL.Path, "/containers/json"):
+			io.WriteString(w, `[{"Id":"aaa","Names":["/web"],"Image":"nginx:1.21","State":"running","Status":"Up 1 hour"}]`)
+		case strings.HasSuffix(r.URL.Path, "/nodes"):
+			// worker不能列出节点
+			atomic.AddInt32(&nodeLists, 1)
+			http.Error(w, `{"message":"This node is not a swarm manager."}`, http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	collectors := map[string]bool{"state": true, "counts": true, "swarm-tasks": true}
+	basic, extended, err := SplitExtended(collectors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	DisableSwarmTasksUnlessManager(collectors, basic, extended)
+	for _, set := range []map[string]bool{collectors, basic, extended} {
+		if set["swarm-tasks"] {
+			t.Errorf("swarm-tasks still enabled in %v", set)
+		}
+	}
+
+	families := gather(t, NewExporter(&Config{}, basic))
+	if n := atomic.LoadInt32(&nodeLists); n != 0 {
+		t.Errorf("listed nodes %d times on a worker", n)
+	}
+	if m := findMetric(families["container_exporter_scrape_success"], nil); m == nil || metricValue(m) != 1 {
+		t.Errorf("container_exporter_scrape_success = %v, want 1", m)
+	}
+	if m := findMetric(families["container_exporter_collector_errors_total"], map[string]string{"collector": "swarm-tasks"}); m != nil && metricValue(m) != 0 {
+		t.Errorf("container_exporter_collector_errors_total{collector=swarm-tasks} = %v, want 0", metricValue(m))
+	}
+}
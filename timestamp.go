@@ -0,0 +1,28 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TimestampedCollector 给内部采集器输出的所有指标附加采集时间，用于 --metric-timestamps
+type TimestampedCollector struct {
+	prometheus.Collector
+}
+
+// Collect 以开始采集的时间作为这一次采集中所有指标的时间戳，精确到毫秒
+func (c TimestampedCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	metrics := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for metric := range metrics {
+			ch <- prometheus.NewMetricWithTimestamp(now, metric)
+		}
+	}()
+	c.Collector.Collect(metrics)
+	close(metrics)
+	<-done
+}